@@ -0,0 +1,108 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// databaseConfig holds the settings every program shares. It is embedded in
+// the config of each program, which adds its own settings.
+type databaseConfig struct {
+	// SQLite file or postgres:// URL
+	Database string `yaml:"database"`
+	// milliseconds SQLite waits for a lock
+	BusyTimeout int `yaml:"busyTimeout"`
+}
+
+var defaultDatabaseConfig = databaseConfig{
+	Database:    "./FootballTracker.db",
+	BusyTimeout: 10000,
+}
+
+// readConfig reads config.yaml, or the file named by CONFIG_FILE, into
+// settings and then the database settings from the environment. Neither the
+// config file nor .env have to exist. The programs read their own settings
+// from the environment afterwards.
+func readConfig(settings interface{}, database *databaseConfig) error {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to load .env: %v", err)
+	}
+
+	path := os.Getenv("CONFIG_FILE")
+	data, err := os.ReadFile(cmp.Or(path, "config.yaml"))
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, settings); err != nil {
+			return fmt.Errorf("failed to parse config file: %v", err)
+		}
+	case path != "" || !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if value := os.Getenv("DATABASE_URL"); value != "" {
+		database.Database = value
+	}
+	if value := os.Getenv("DATABASE_BUSY_TIMEOUT"); value != "" {
+		database.BusyTimeout, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid DATABASE_BUSY_TIMEOUT %q: %v", value, err)
+		}
+	}
+	return nil
+}
+
+// parseSettings reads name=value pairs such as "goal=20,winner=30" from an
+// environment variable into settings
+func parseSettings(variable string, settings map[string]float64) error {
+	value := os.Getenv(variable)
+	if value == "" {
+		return nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		name, number, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid %s: %q is not name=value", variable, pair)
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", variable, err)
+		}
+		settings[strings.TrimSpace(name)] = parsed
+	}
+	return nil
+}
+
+func settingNames(settings map[string]float64) []string {
+	var names []string
+	for name := range settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// checkSettings makes sure a section holds exactly the known names, so a
+// misspelt name is reported instead of silently ignored
+func checkSettings(section string, settings map[string]float64, names []string) error {
+	for name := range settings {
+		if !slices.Contains(names, name) {
+			return fmt.Errorf("unknown name %q in %s, expected one of %s", name, section, strings.Join(names, ", "))
+		}
+	}
+	for _, name := range names {
+		if _, ok := settings[name]; !ok {
+			return fmt.Errorf("missing %q in %s", name, section)
+		}
+	}
+	return nil
+}
@@ -2,375 +2,1327 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math"
-	"strings"
-
-	_ "github.com/mattn/go-sqlite3"
+	"os"
+	"strconv"
 )
 
-var db *sql.DB
+// config holds the settings of this program. The defaults below are
+// overridden by the config file, and the config file by the environment.
+type config struct {
+	databaseConfig `yaml:",inline"`
+	// K-factor of each Elo component
+	KFactors map[string]float64 `yaml:"kFactors"`
+	// weights of the Elo components in the combined rating, without and with
+	// expected goals; generateChances has to use the same weights
+	Weights              map[string]float64 `yaml:"weights"`
+	ExpectedGoalsWeights map[string]float64 `yaml:"expectedGoalsWeights"`
+	// share of the xG-for and xG-against Elos in the attack and defence
+	// strengths of the Poisson model; generateChances has to use the same
+	ExpectedGoalsShare float64 `yaml:"expectedGoalsShare"`
+}
 
-func initDB() error {
-	var err error
-	db, err = sql.Open("sqlite3", "./FootballTracker.db?_timeout=10000&_busy_timeout=10000")
-	if err != nil {
-		return fmt.Errorf("failed to open database: %v", err)
+var cfg = config{
+	databaseConfig: defaultDatabaseConfig,
+	KFactors: map[string]float64{
+		"goal": 25, "winner": 25, "ballPossession": 25, "totalShots": 25,
+		"attack": 25, "defence": 25, "expectedGoals": 25,
+	},
+	Weights: map[string]float64{
+		"goal": 0.4, "winner": 0.3, "totalShots": 0.15, "ballPossession": 0.15,
+	},
+	ExpectedGoalsWeights: map[string]float64{
+		"goal": 0.3, "winner": 0.25, "expectedGoals": 0.25, "totalShots": 0.1, "ballPossession": 0.1,
+	},
+	ExpectedGoalsShare: 0.5,
+}
+
+// loadConfig reads config.yaml, or the file named by CONFIG_FILE, and then
+// the environment. Neither the config file nor .env have to exist.
+func loadConfig() error {
+	// the names of a section are fixed by its defaults
+	kFactorNames := settingNames(cfg.KFactors)
+	weightNames := settingNames(cfg.Weights)
+	expectedGoalsWeightNames := settingNames(cfg.ExpectedGoalsWeights)
+
+	if err := readConfig(&cfg, &cfg.databaseConfig); err != nil {
+		return err
+	}
+
+	if value := os.Getenv("EXPECTED_GOALS_SHARE"); value != "" {
+		share, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid EXPECTED_GOALS_SHARE %q: %v", value, err)
+		}
+		cfg.ExpectedGoalsShare = share
+	}
+	if cfg.ExpectedGoalsShare < 0 || cfg.ExpectedGoalsShare > 1 {
+		return fmt.Errorf("expectedGoalsShare must be between 0 and 1")
+	}
+	if err := parseSettings("ELO_K_FACTORS", cfg.KFactors); err != nil {
+		return err
+	}
+	if err := parseSettings("ELO_WEIGHTS", cfg.Weights); err != nil {
+		return err
+	}
+	if err := parseSettings("ELO_EXPECTED_GOALS_WEIGHTS", cfg.ExpectedGoalsWeights); err != nil {
+		return err
 	}
 
-	// Test the connection
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %v", err)
+	if err := checkSettings("kFactors", cfg.KFactors, kFactorNames); err != nil {
+		return err
+	}
+	if err := checkSettings("weights", cfg.Weights, weightNames); err != nil {
+		return err
 	}
+	return checkSettings("expectedGoalsWeights", cfg.ExpectedGoalsWeights, expectedGoalsWeightNames)
+}
 
-	return nil
+func normalizeScore(max float64, min float64, score float64) float64 {
+	return (score - min) / (max - min)
 }
 
-func updateEloForTeam(elotype string, teamId int, elo float64) {
-	query := fmt.Sprintf("UPDATE elo SET %s = ? WHERE team = ?", elotype)
-	_, err := db.Exec(query, elo, teamId)
-	if err != nil {
-		log.Printf("Error updating Elo: %v", err)
+func calcExpectedElo(opponentElo float64, TeamElo float64) float64 {
+	newElo := 1 / (1 + math.Pow(10, (opponentElo-TeamElo)/400))
+
+	return newElo
+}
+
+func updateEloForScores(TeamElo float64, expectedScore float64, score float64, kFactor float64) float64 {
+	updatedElo := TeamElo + kFactor*(score-expectedScore)
+
+	return updatedElo
+}
+
+// combineElo weights the component ratings the same way generateChances does,
+// so the backtest measures the ratings the predictor actually uses.
+func combineElo(goalElo float64, winnerElo float64, totalShotsElo float64, ballPossessionElo float64) float64 {
+	w := cfg.Weights
+	return goalElo*w["goal"] + winnerElo*w["winner"] + totalShotsElo*w["totalShots"] + ballPossessionElo*w["ballPossession"]
+}
+
+func combineEloWithExpectedGoals(goalElo float64, winnerElo float64, totalShotsElo float64, ballPossessionElo float64, expectedGoalsElo float64) float64 {
+	w := cfg.ExpectedGoalsWeights
+	return goalElo*w["goal"] + winnerElo*w["winner"] + expectedGoalsElo*w["expectedGoals"] + totalShotsElo*w["totalShots"] + ballPossessionElo*w["ballPossession"]
+}
+
+// getOutcome is the result from the home team's side, counting a draw as half a win
+func getOutcome(homeTeamScore int, awayTeamScore int) float64 {
+	if homeTeamScore > awayTeamScore {
+		return 1
+	} else if homeTeamScore < awayTeamScore {
+		return 0
 	}
+	return 0.5
+}
+
+// goalDifferenceDeviation is the spread of the actual goal difference around
+// the expected one. It is shared with the pi-ratings engine.
+const goalDifferenceDeviation = 1.6
+
+func normalCDF(x float64) float64 {
+	return 0.5 * (1 + math.Erf(x/math.Sqrt2))
+}
+
+// calcOutcomeProbabilities splits a home win probability (draws counting
+// half) into home, draw and away probabilities. It finds the expected goal
+// difference that gives this probability under a normal distribution and
+// treats anything within half a goal of zero as a draw.
+func calcOutcomeProbabilities(homeWinProbability float64) [3]float64 {
+	p := math.Min(math.Max(homeWinProbability, 1e-6), 1-1e-6)
+	goalDifference := goalDifferenceDeviation * math.Sqrt2 * math.Erfinv(2*p-1)
+
+	home := 1 - normalCDF((0.5-goalDifference)/goalDifferenceDeviation)
+	away := normalCDF((-0.5 - goalDifference) / goalDifferenceDeviation)
+	return [3]float64{home, 1 - home - away, away}
 }
 
-func enterDataIntoDB(table string, columns []string, data []interface{}) error {
-	// Create placeholders for the SQL query
-	placeholders := make([]string, len(columns))
-	for i := range placeholders {
-		placeholders[i] = "?"
+// logLoss scores a home win probability against the result
+func logLoss(homeWinProbability float64, homeTeamScore int, awayTeamScore int) float64 {
+	outcome := getOutcome(homeTeamScore, awayTeamScore)
+
+	p := math.Min(math.Max(homeWinProbability, 1e-15), 1-1e-15)
+	return -(outcome*math.Log(p) + (1-outcome)*math.Log(1-p))
+}
+
+// outcomeLogLoss scores 1X2 probabilities against the result
+func outcomeLogLoss(outcomes [3]float64, homeTeamScore int, awayTeamScore int) float64 {
+	probability := outcomes[1]
+	if homeTeamScore > awayTeamScore {
+		probability = outcomes[0]
+	} else if homeTeamScore < awayTeamScore {
+		probability = outcomes[2]
 	}
+	return -math.Log(math.Max(probability, 1e-15))
+}
 
-	// Construct the SQL query
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		table,
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "))
+// calcExpectedGoals is the Poisson input of generateChances: the league
+// average scaled by how the attack fares against the defence
+func calcExpectedGoals(attackElo float64, defenceElo float64, averageGoals float64) float64 {
+	return averageGoals * 2 / (1 + math.Pow(10, (defenceElo-attackElo)/400))
+}
 
-	// Execute the query
-	_, err := db.Exec(query, data...)
-	if err != nil {
-		return fmt.Errorf("failed to insert data: %v", err)
+func poissonProbability(goals int, expectedGoals float64) float64 {
+	probability := math.Exp(-expectedGoals)
+	for i := 1; i <= goals; i++ {
+		probability *= expectedGoals / float64(i)
 	}
-	return nil
+	return probability
+}
+
+// calcPoissonOutcomes returns the 1X2 probabilities of two independent
+// Poisson goal counts, the same way generateChances does
+func calcPoissonOutcomes(homeExpectedGoals float64, awayExpectedGoals float64) [3]float64 {
+	var outcomes [3]float64
+	for homeGoals := 0; homeGoals <= 10; homeGoals++ {
+		for awayGoals := 0; awayGoals <= 10; awayGoals++ {
+			probability := poissonProbability(homeGoals, homeExpectedGoals) * poissonProbability(awayGoals, awayExpectedGoals)
+			if homeGoals > awayGoals {
+				outcomes[0] += probability
+			} else if homeGoals < awayGoals {
+				outcomes[2] += probability
+			} else {
+				outcomes[1] += probability
+			}
+		}
+	}
+
+	total := outcomes[0] + outcomes[1] + outcomes[2]
+	return [3]float64{outcomes[0] / total, outcomes[1] / total, outcomes[2] / total}
 }
 
-func getDB() *sql.DB {
-	return db
+// calcAttackScore blends goals scored and shots taken into a single score for
+// the attack rating; the opponent's defence is rated on the complement.
+func calcAttackScore(normalizedGoals float64, normalizedShots float64) float64 {
+	return normalizedGoals*0.7 + normalizedShots*0.3
 }
 
-func closeDB() {
-	if db != nil {
-		db.Close()
+type backtestResult struct {
+	fixtures int
+	logLoss  float64
+	// 1X2 log-loss of the Poisson model, for engines that predict goals
+	poissonFixtures int
+	poissonLogLoss  float64
+}
+
+func getWinnerScore(homeTeamScore int, awayTeamScore int) (float64, float64) {
+	if homeTeamScore > awayTeamScore {
+		return 1, 0
 	}
+	return 0, 1
 }
 
-func normalizeScore(max float64, min float64, score float64) float64 {
-	return (score - min) / (max - min)
+type fixture struct {
+	fixtureId     int
+	homeTeamId    int
+	awayTeamId    int
+	homeTeamScore int
+	awayTeamScore int
+	round         string
+	date          string
+}
+
+// ratingEngine is implemented by every rating system so they can be replayed
+// and backtested the same way.
+type ratingEngine interface {
+	reset() error
+	// load restores the ratings saved by the previous run
+	load() error
+	// predict returns the probability that the home team wins
+	predict(homeTeamId int, awayTeamId int) float64
+	// rating is the team's overall strength, recorded in ratingHistory
+	rating(teamId int) float64
+	processRatingPeriod(fixtures []fixture)
+	// save writes the ratings as part of the run's transaction
+	save(tx *sql.Tx) error
+}
+
+// goalPredictor is implemented by engines whose ratings also drive the
+// Poisson model of generateChances, so that model is backtested too
+type goalPredictor interface {
+	// predictGoals returns the expected goals of both teams
+	predictGoals(homeTeamId int, awayTeamId int, averageGoals float64) (float64, float64)
+}
+
+// loadRatingPeriods returns the fixtures in date order, grouped by matchday.
+// A period is a run of consecutive fixtures of the same round, so a postponed
+// fixture played weeks later forms a period of its own at its date instead of
+// being rated with its original round. With onlyNew the fixtures the engine
+// has already applied are left out. Fixtures without a round are grouped by
+// day, and those without either form a period of their own. Fixtures without
+// a date come last on SQLite and PostgreSQL alike.
+func loadRatingPeriods(name string, onlyNew bool) ([][]fixture, error) {
+	query := `
+		SELECT fixtureId, homeTeam, awayTeam, homeTeamScore, awayTeamScore, round, date, season FROM fixtures
+		WHERE NOT ? OR fixtureId NOT IN (SELECT fixtureId FROM appliedFixtures WHERE engine = ?)
+		ORDER BY date IS NULL, date, fixtureId
+	`
+
+	rows, err := db.Query(query, onlyNew, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fixtures: %v", err)
+	}
+	defer rows.Close()
+
+	var periods [][]fixture
+	var lastKey string
+
+	for rows.Next() {
+		var f fixture
+		var round sql.NullString
+		var date sql.NullString
+		var season sql.NullString
+
+		if err := rows.Scan(&f.fixtureId, &f.homeTeamId, &f.awayTeamId, &f.homeTeamScore, &f.awayTeamScore, &round, &date, &season); err != nil {
+			return nil, fmt.Errorf("failed to scan fixture: %v", err)
+		}
+		f.round = round.String
+		f.date = date.String
+
+		// round names repeat every season
+		var key string
+		switch {
+		case f.round != "":
+			key = "round " + season.String + " " + f.round
+		case len(f.date) >= 10:
+			key = "day " + f.date[:10]
+		}
+
+		if key != "" && key == lastKey {
+			periods[len(periods)-1] = append(periods[len(periods)-1], f)
+			continue
+		}
+		lastKey = key
+		periods = append(periods, []fixture{f})
+	}
+
+	return periods, rows.Err()
 }
 
-func getCurrentEloFromDB(elotype string, teamId int) float64 {
-	query := fmt.Sprintf("SELECT %s FROM elo WHERE team = ?", elotype)
-	row := db.QueryRow(query, teamId)
+// runRatingEngine applies every fixture the engine has not seen yet, period by
+// period, predicting each fixture with the ratings from before its matchday so
+// the log-loss is an honest backtest. The rating of every team after each of
+// its fixtures is stored under name. With rebuild, when the engine has not
+// applied any fixture yet, or when it cannot continue from its stored ratings,
+// the ratings start over and every fixture is replayed.
+func runRatingEngine(name string, engine ratingEngine, rebuild bool) (backtestResult, error) {
+	var result backtestResult
+
+	var applied int
+	if err := db.QueryRow("SELECT COUNT(*) FROM appliedFixtures WHERE engine = ?", name).Scan(&applied); err != nil {
+		return result, fmt.Errorf("failed to count applied fixtures: %v", err)
+	}
+	rebuild = rebuild || applied == 0
+
+	if !rebuild {
+		err := engine.load()
+		if errors.Is(err, errRebuildNeeded) {
+			fmt.Printf("Rebuilding %s: %v\n", name, err)
+			rebuild = true
+		} else if err != nil {
+			return result, err
+		}
+	}
+	if rebuild {
+		if err := engine.reset(); err != nil {
+			return result, err
+		}
+	}
+
+	periods, err := loadRatingPeriods(name, !rebuild)
+	if err != nil {
+		return result, err
+	}
+	if len(periods) == 0 && !rebuild {
+		return result, nil
+	}
+
+	// the Poisson model needs the average goals, taken only from the
+	// fixtures before each matchday
+	var goals, goalFixtures int
+	if !rebuild {
+		query := `
+			SELECT COALESCE(SUM(homeTeamScore + awayTeamScore), 0), COUNT(*) FROM fixtures
+			WHERE fixtureId IN (SELECT fixtureId FROM appliedFixtures WHERE engine = ?)
+		`
+		if err := db.QueryRow(query, name).Scan(&goals, &goalFixtures); err != nil {
+			return result, fmt.Errorf("failed to count goals: %v", err)
+		}
+	}
+	goalModel, predictsGoals := engine.(goalPredictor)
 
-	var elo sql.NullFloat64
-	err := row.Scan(&elo)
+	// everything is written in one transaction, so a failed run leaves the
+	// ratings of the previous run in place
+	tx, err := db.Begin()
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// No existing Elo rating found, insert default value
-			_, err := db.Exec(fmt.Sprintf("INSERT INTO elo (team, %s) VALUES (?, ?)", elotype), teamId, 1000)
-			fmt.Println("Inserting default Elo")
+		return result, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if rebuild {
+		for _, table := range []string{"ratingHistory", "backtestPredictions", "appliedFixtures"} {
+			if err := checkIdentifiers(table, "engine"); err != nil {
+				return result, err
+			}
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE engine = ?", table), name); err != nil {
+				return result, fmt.Errorf("failed to clear %s: %v", table, err)
+			}
+		}
+	}
+
+	for _, period := range periods {
+		for _, f := range period {
+			homeWinProbability := engine.predict(f.homeTeamId, f.awayTeamId)
+			result.logLoss += logLoss(homeWinProbability, f.homeTeamScore, f.awayTeamScore)
+			result.fixtures++
+
+			if predictsGoals && goals > 0 {
+				averageGoals := float64(goals) / float64(goalFixtures) / 2
+				homeExpectedGoals, awayExpectedGoals := goalModel.predictGoals(f.homeTeamId, f.awayTeamId, averageGoals)
+				result.poissonLogLoss += outcomeLogLoss(calcPoissonOutcomes(homeExpectedGoals, awayExpectedGoals), f.homeTeamScore, f.awayTeamScore)
+				result.poissonFixtures++
+			}
+
+			outcomes := calcOutcomeProbabilities(homeWinProbability)
+			err := enterDataIntoDB(tx, "backtestPredictions", []string{"engine", "fixtureId", "date", "homeWin", "outcome", "probabilityHome", "probabilityDraw", "probabilityAway"}, []interface{}{name, f.fixtureId, f.date, homeWinProbability, getOutcome(f.homeTeamScore, f.awayTeamScore), outcomes[0], outcomes[1], outcomes[2]})
 			if err != nil {
-				log.Printf("Error inserting default Elo: %v", err)
+				return result, err
+			}
+		}
+		engine.processRatingPeriod(period)
+
+		for _, f := range period {
+			goals += f.homeTeamScore + f.awayTeamScore
+			goalFixtures++
+
+			for _, teamId := range []int{f.homeTeamId, f.awayTeamId} {
+				err := enterDataIntoDB(tx, "ratingHistory", []string{"engine", "fixtureId", "date", "team", "rating"}, []interface{}{name, f.fixtureId, f.date, teamId, engine.rating(teamId)})
+				if err != nil {
+					return result, err
+				}
+			}
+
+			err := enterDataIntoDB(tx, "appliedFixtures", []string{"engine", "fixtureId"}, []interface{}{name, f.fixtureId})
+			if err != nil {
+				return result, err
 			}
-			return 1000
 		}
-		log.Printf("Error querying Elo: %v", err)
-		return 1000
 	}
 
-	if elo.Valid {
-		return elo.Float64
+	if err := engine.save(tx); err != nil {
+		return result, err
 	}
-	return 1000 // Return default value if NULL
+	return result, tx.Commit()
 }
 
-func calcExpectedElo(opponentElo float64, TeamElo float64) float64 {
-	newElo := 1 / (1 + math.Pow(10, (opponentElo-TeamElo)/400))
+// eloRating holds the raw component Elos of one team. The xG Elos stay
+// invalid until the team plays a fixture with xG coverage.
+type eloRating struct {
+	goal           float64
+	winner         float64
+	ballPossession float64
+	totalShots     float64
+	attack         float64
+	defence        float64
+	expectedGoals  sql.NullFloat64
+	// xG-for and xG-against strengths, rated like attack and defence but on
+	// the xG created and conceded instead of goals and shots
+	expectedGoalsAttack  sql.NullFloat64
+	expectedGoalsDefence sql.NullFloat64
+}
 
-	return newElo
+// fixtureTeam identifies one team's statistics in one fixture
+type fixtureTeam struct {
+	fixtureId int
+	teamId    int
 }
 
-func updateEloForScores(TeamElo float64, expectedScore float64, score float64, kFactor float64) float64 {
-	updatedElo := TeamElo + kFactor*(score-expectedScore)
+// statisticRange is the smallest and largest value of a statistic over all
+// fixtures, used to normalize single values to 0-1
+type statisticRange struct {
+	min float64
+	max float64
+}
 
-	return updatedElo
+// eloStatistics are the match statistics every Elo update needs, loaded once
+// per run. Missing statistics have no entry.
+type eloStatistics struct {
+	scores              statisticRange
+	totalShots          map[fixtureTeam]float64
+	totalShotsRange     statisticRange
+	ballPossession      map[fixtureTeam]float64
+	ballPossessionRange statisticRange
+	expectedGoals       map[fixtureTeam]float64
+	expectedGoalsRange  statisticRange
+}
+
+func loadTeamStatistic(table string, column string) (map[fixtureTeam]float64, statisticRange, error) {
+	values := make(map[fixtureTeam]float64)
+	var valueRange statisticRange
+
+	if err := checkIdentifiers(table, "fixtureId", "team", column); err != nil {
+		return nil, valueRange, err
+	}
+
+	query := fmt.Sprintf("SELECT fixtureId, team, %s FROM %s WHERE %s IS NOT NULL", column, table, column)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, valueRange, fmt.Errorf("failed to load %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	first := true
+	for rows.Next() {
+		var key fixtureTeam
+		var value float64
+		if err := rows.Scan(&key.fixtureId, &key.teamId, &value); err != nil {
+			return nil, valueRange, fmt.Errorf("failed to scan %s: %v", table, err)
+		}
+		values[key] = value
+
+		if first || value < valueRange.min {
+			valueRange.min = value
+		}
+		if first || value > valueRange.max {
+			valueRange.max = value
+		}
+		first = false
+	}
+
+	return values, valueRange, rows.Err()
 }
 
-func getMaxMinScore() (float64, float64) {
-	var maxScore float64
-	var minScore float64
+// ranges names the ranges the statistics are normalized with
+func (s *eloStatistics) ranges() map[string]statisticRange {
+	return map[string]statisticRange{
+		"scores":         s.scores,
+		"totalShots":     s.totalShotsRange,
+		"ballPossession": s.ballPossessionRange,
+		"expectedGoals":  s.expectedGoalsRange,
+	}
+}
+
+func loadEloStatistics() (*eloStatistics, error) {
+	stats := &eloStatistics{}
 
 	query := `
 		SELECT 
-			MAX(CASE WHEN homeTeamScore > awayTeamScore THEN homeTeamScore ELSE awayTeamScore END) as max_score,
-			MIN(CASE WHEN homeTeamScore < awayTeamScore THEN homeTeamScore ELSE awayTeamScore END) as min_score
+			COALESCE(MIN(CASE WHEN homeTeamScore < awayTeamScore THEN homeTeamScore ELSE awayTeamScore END), 0) as min_score,
+			COALESCE(MAX(CASE WHEN homeTeamScore > awayTeamScore THEN homeTeamScore ELSE awayTeamScore END), 0) as max_score
 		FROM fixtures
 	`
-	row := db.QueryRow(query)
-	err := row.Scan(&maxScore, &minScore)
+	if err := db.QueryRow(query).Scan(&stats.scores.min, &stats.scores.max); err != nil {
+		return nil, fmt.Errorf("failed to query max and min scores: %v", err)
+	}
+
+	var err error
+	stats.totalShots, stats.totalShotsRange, err = loadTeamStatistic("totalShots", "totalShots")
 	if err != nil {
-		log.Printf("Error querying max and min scores: %v", err)
-		return 0, 0
+		return nil, err
 	}
+	stats.ballPossession, stats.ballPossessionRange, err = loadTeamStatistic("ballPossession", "ballPossession")
+	if err != nil {
+		return nil, err
+	}
+	stats.expectedGoals, stats.expectedGoalsRange, err = loadTeamStatistic("expectedGoals", "expectedGoals")
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
 
-	return maxScore, minScore
+// pair returns the statistic of both teams. ok is false when either is missing.
+func pair(values map[fixtureTeam]float64, fixtureId int, homeTeamId int, awayTeamId int) (float64, float64, bool) {
+	home, homeOk := values[fixtureTeam{fixtureId, homeTeamId}]
+	away, awayOk := values[fixtureTeam{fixtureId, awayTeamId}]
+	return home, away, homeOk && awayOk
 }
 
-func getBallPossessionScore(homeTeam int, awayTeam int, fixtureId int) (float64, float64, float64, float64) {
-	homeTeamQuery := fmt.Sprintf("SELECT ballPossession FROM ballPossession WHERE fixtureId = %d AND teamId = %d", fixtureId, homeTeam)
-	awayTeamQuery := fmt.Sprintf("SELECT ballPossession FROM ballPossession WHERE fixtureId = %d AND teamId = %d", fixtureId, awayTeam)
+// getExpectedGoalsScore returns each team's share of the match xG, which is used
+// as the score for the xG Elo. ok is false when the fixture has no xG coverage.
+func (s *eloStatistics) getExpectedGoalsScore(fixtureId int, homeTeamId int, awayTeamId int) (float64, float64, bool) {
+	homeTeamExpectedGoals, awayTeamExpectedGoals, ok := pair(s.expectedGoals, fixtureId, homeTeamId, awayTeamId)
+	if !ok {
+		return 0, 0, false
+	}
+
+	totalExpectedGoals := homeTeamExpectedGoals + awayTeamExpectedGoals
+	if totalExpectedGoals == 0 {
+		return 0.5, 0.5, true
+	}
 
-	minMaxQuery := "SELECT MIN(ballPossession), MAX(ballPossession) FROM ballPossession"
+	return homeTeamExpectedGoals / totalExpectedGoals, awayTeamExpectedGoals / totalExpectedGoals, true
+}
+
+// eloEngine rates teams on component Elos. Ratings are computed in memory;
+// the raw ratings are saved to eloRaw so the next run can continue from them
+// and the elo table gets the normalized ratings generateChances reads.
+type eloEngine struct {
+	// expectedGoals includes the xG component when predicting
+	expectedGoals bool
 
-	var minScore float64
-	var maxScore float64
+	ratings map[int]*eloRating
+	stats   *eloStatistics
+}
 
-	var homeTeamBallPossession float64
-	var awayTeamBallPossession float64
+func (e *eloEngine) reset() error {
+	e.ratings = make(map[int]*eloRating)
+
+	stats, err := loadEloStatistics()
+	if err != nil {
+		return err
+	}
+	e.stats = stats
+	return nil
+}
 
-	row := db.QueryRow(homeTeamQuery)
-	row.Scan(&homeTeamBallPossession)
+// errRebuildNeeded is returned by load when continuing from the stored
+// ratings would not give the ratings a rebuild gives
+var errRebuildNeeded = errors.New("rebuild needed")
+
+// load continues from the raw ratings of the last run. The statistics are
+// normalized with their ranges over all fixtures, so once a new fixture
+// widens a range the stored ratings are on another scale than a rebuild's,
+// and load asks for a rebuild instead.
+func (e *eloEngine) load() error {
+	if err := e.reset(); err != nil {
+		return err
+	}
+
+	stored := make(map[string]statisticRange)
+	rows, err := db.Query("SELECT statistic, minimum, maximum FROM eloRanges")
+	if err != nil {
+		return fmt.Errorf("failed to load statistic ranges: %v", err)
+	}
+	for rows.Next() {
+		var statistic string
+		var r statisticRange
+		if err := rows.Scan(&statistic, &r.min, &r.max); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan statistic range: %v", err)
+		}
+		stored[statistic] = r
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for statistic, r := range e.stats.ranges() {
+		if s, ok := stored[statistic]; !ok || s != r {
+			return fmt.Errorf("%w, the range of %s changed from %v to %v", errRebuildNeeded, statistic, s, r)
+		}
+	}
 
-	row = db.QueryRow(awayTeamQuery)
-	row.Scan(&awayTeamBallPossession)
+	rows, err = db.Query("SELECT team, goalElo, winnerElo, ballPossessionElo, totalShotsElo, expectedGoalsElo, attackElo, defenceElo, expectedGoalsAttackElo, expectedGoalsDefenceElo FROM eloRaw")
+	if err != nil {
+		return fmt.Errorf("failed to load raw elo ratings: %v", err)
+	}
+	defer rows.Close()
 
-	row = db.QueryRow(minMaxQuery)
-	row.Scan(&minScore, &maxScore)
+	for rows.Next() {
+		var teamId int
+		r := &eloRating{}
+		if err := rows.Scan(&teamId, &r.goal, &r.winner, &r.ballPossession, &r.totalShots, &r.expectedGoals, &r.attack, &r.defence, &r.expectedGoalsAttack, &r.expectedGoalsDefence); err != nil {
+			return fmt.Errorf("failed to scan raw elo rating: %v", err)
+		}
+		e.ratings[teamId] = r
+	}
+	return rows.Err()
+}
 
-	return homeTeamBallPossession, awayTeamBallPossession, maxScore, minScore
+// get returns the team's ratings, starting new teams at 1000
+func (e *eloEngine) get(teamId int) *eloRating {
+	r, ok := e.ratings[teamId]
+	if !ok {
+		r = &eloRating{goal: 1000, winner: 1000, ballPossession: 1000, totalShots: 1000, attack: 1000, defence: 1000}
+		e.ratings[teamId] = r
+	}
+	return r
 }
 
-func getShotsOnTargetScore(homeTeam int, awayTeam int, fixtureId int) (float64, float64, float64, float64) {
-	homeTeamQuery := fmt.Sprintf("SELECT totalShots FROM totalShots WHERE fixtureId = %d AND teamId = %d", fixtureId, homeTeam)
-	awayTeamQuery := fmt.Sprintf("SELECT totalShots FROM totalShots WHERE fixtureId = %d AND teamId = %d", fixtureId, awayTeam)
+// expectedGoalsElo is the xG Elo, 1000 until the team has xG coverage
+func (r *eloRating) expectedGoalsElo() float64 {
+	return nullElo(r.expectedGoals)
+}
 
-	minMaxQuery := "SELECT MIN(totalShots), MAX(totalShots) FROM totalShots"
+// nullElo is the rating of a component without coverage yet
+func nullElo(rating sql.NullFloat64) float64 {
+	if rating.Valid {
+		return rating.Float64
+	}
+	return 1000
+}
 
-	var minScore float64
-	var maxScore float64
+// blendStrength mixes a goal-based attack or defence Elo with its xG
+// counterpart, if the team has one
+func blendStrength(elo float64, expectedGoalsElo sql.NullFloat64) float64 {
+	if !expectedGoalsElo.Valid {
+		return elo
+	}
+	return (1-cfg.ExpectedGoalsShare)*elo + cfg.ExpectedGoalsShare*expectedGoalsElo.Float64
+}
 
-	var homeTeamShotsOnTarget float64
-	var awayTeamShotsOnTarget float64
+// predictGoals feeds the attack and defence Elos, blended with the xG-for and
+// xG-against Elos when xG is used, into the Poisson model
+func (e *eloEngine) predictGoals(homeTeamId int, awayTeamId int, averageGoals float64) (float64, float64) {
+	home := e.get(homeTeamId)
+	away := e.get(awayTeamId)
+
+	homeAttack, homeDefence := home.attack, home.defence
+	awayAttack, awayDefence := away.attack, away.defence
+	if e.expectedGoals {
+		homeAttack = blendStrength(homeAttack, home.expectedGoalsAttack)
+		homeDefence = blendStrength(homeDefence, home.expectedGoalsDefence)
+		awayAttack = blendStrength(awayAttack, away.expectedGoalsAttack)
+		awayDefence = blendStrength(awayDefence, away.expectedGoalsDefence)
+	}
+	return calcExpectedGoals(homeAttack, awayDefence, averageGoals), calcExpectedGoals(awayAttack, homeDefence, averageGoals)
+}
 
-	row := db.QueryRow(homeTeamQuery)
-	row.Scan(&homeTeamShotsOnTarget)
+func (e *eloEngine) combinedElo(teamId int) float64 {
+	r := e.get(teamId)
 
-	row = db.QueryRow(awayTeamQuery)
-	row.Scan(&awayTeamShotsOnTarget)
+	if !e.expectedGoals {
+		return combineElo(r.goal, r.winner, r.totalShots, r.ballPossession)
+	}
+	return combineEloWithExpectedGoals(r.goal, r.winner, r.totalShots, r.ballPossession, r.expectedGoalsElo())
+}
 
-	row = db.QueryRow(minMaxQuery)
-	row.Scan(&minScore, &maxScore)
+func (e *eloEngine) rating(teamId int) float64 {
+	return e.combinedElo(teamId)
+}
 
-	return homeTeamShotsOnTarget, awayTeamShotsOnTarget, maxScore, minScore
+func (e *eloEngine) predict(homeTeamId int, awayTeamId int) float64 {
+	return calcExpectedElo(e.combinedElo(awayTeamId), e.combinedElo(homeTeamId))
 }
 
-func getWinnerScore(homeTeamScore int, awayTeamScore int) (float64, float64) {
-	if homeTeamScore > awayTeamScore {
-		return 1, 0
+func (e *eloEngine) processRatingPeriod(fixtures []fixture) {
+	for _, f := range fixtures {
+		e.updateForFixture(f)
 	}
-	return 0, 1
 }
 
-func calcEloForScores() {
-	query := "SELECT * FROM fixtures"
+// updateForFixture applies one played fixture to every Elo component. Shots
+// and ball possession are skipped when the fixture has no such statistics,
+// and the xG Elo when it has no xG coverage.
+func (e *eloEngine) updateForFixture(f fixture) {
+	home := e.get(f.homeTeamId)
+	away := e.get(f.awayTeamId)
+	stats := e.stats
+
+	//score
+	normalizedHomeTeamScore := normalizeScore(stats.scores.max, stats.scores.min, float64(f.homeTeamScore))
+	normalizedAwayTeamScore := normalizeScore(stats.scores.max, stats.scores.min, float64(f.awayTeamScore))
+
+	expectedHomeTeamScore := calcExpectedElo(away.goal, home.goal)
+	expectedAwayTeamScore := calcExpectedElo(home.goal, away.goal)
+
+	//winner
+	//the normalized value is always 1 or 0
+	homeTeamWinnerValue, awayTeamWinnerValue := getWinnerScore(f.homeTeamScore, f.awayTeamScore)
+
+	expectedHomeTeamWinner := calcExpectedElo(away.winner, home.winner)
+	expectedAwayTeamWinner := calcExpectedElo(home.winner, away.winner)
+
+	//ball possession
+	homeTeamBallPossession, awayTeamBallPossession, hasBallPossession := pair(stats.ballPossession, f.fixtureId, f.homeTeamId, f.awayTeamId)
+	normalizedHomeTeamBallPossession := normalizeScore(stats.ballPossessionRange.max, stats.ballPossessionRange.min, homeTeamBallPossession)
+	normalizedAwayTeamBallPossession := normalizeScore(stats.ballPossessionRange.max, stats.ballPossessionRange.min, awayTeamBallPossession)
+
+	expectedHomeTeamBallPossession := calcExpectedElo(away.ballPossession, home.ballPossession)
+	expectedAwayTeamBallPossession := calcExpectedElo(home.ballPossession, away.ballPossession)
+
+	//shots on target
+	homeTeamShotsOnTarget, awayTeamShotsOnTarget, hasShotsOnTarget := pair(stats.totalShots, f.fixtureId, f.homeTeamId, f.awayTeamId)
+	normalizedHomeTeamShotsOnTarget := normalizeScore(stats.totalShotsRange.max, stats.totalShotsRange.min, homeTeamShotsOnTarget)
+	normalizedAwayTeamShotsOnTarget := normalizeScore(stats.totalShotsRange.max, stats.totalShotsRange.min, awayTeamShotsOnTarget)
+
+	expectedHomeTeamShotsOnTarget := calcExpectedElo(away.totalShots, home.totalShots)
+	expectedAwayTeamShotsOnTarget := calcExpectedElo(home.totalShots, away.totalShots)
+
+	//attack and defence
+	//each attack is rated against the opposing defence
+	//without shot statistics only the goals count
+	homeTeamAttackScore := normalizedHomeTeamScore
+	awayTeamAttackScore := normalizedAwayTeamScore
+	if hasShotsOnTarget {
+		homeTeamAttackScore = calcAttackScore(normalizedHomeTeamScore, normalizedHomeTeamShotsOnTarget)
+		awayTeamAttackScore = calcAttackScore(normalizedAwayTeamScore, normalizedAwayTeamShotsOnTarget)
+	}
 
-	rows, err := db.Query(query)
-	if err != nil {
-		log.Fatal(err)
+	expectedHomeTeamAttack := calcExpectedElo(away.defence, home.attack)
+	expectedAwayTeamAttack := calcExpectedElo(home.defence, away.attack)
+
+	//expected goals
+	//already normalized since it is the share of the match xG
+	homeTeamExpectedGoals, awayTeamExpectedGoals, hasExpectedGoals := stats.getExpectedGoalsScore(f.fixtureId, f.homeTeamId, f.awayTeamId)
+	homeTeamExpectedGoalsElo := home.expectedGoalsElo()
+	awayTeamExpectedGoalsElo := away.expectedGoalsElo()
+
+	//xG for and against
+	//each team's xG is normalized over all fixtures and rated like the attack
+	homeTeamExpectedGoalsFor, awayTeamExpectedGoalsFor, _ := pair(stats.expectedGoals, f.fixtureId, f.homeTeamId, f.awayTeamId)
+	normalizedHomeTeamExpectedGoals, normalizedAwayTeamExpectedGoals := 0.5, 0.5
+	if stats.expectedGoalsRange.max > stats.expectedGoalsRange.min {
+		normalizedHomeTeamExpectedGoals = normalizeScore(stats.expectedGoalsRange.max, stats.expectedGoalsRange.min, homeTeamExpectedGoalsFor)
+		normalizedAwayTeamExpectedGoals = normalizeScore(stats.expectedGoalsRange.max, stats.expectedGoalsRange.min, awayTeamExpectedGoalsFor)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var fixtureId int
-		var homeTeamId int
-		var awayTeamId int
-		var homeTeamScore int
-		var awayTeamScore int
+	homeTeamExpectedGoalsAttackElo, awayTeamExpectedGoalsDefenceElo := nullElo(home.expectedGoalsAttack), nullElo(away.expectedGoalsDefence)
+	awayTeamExpectedGoalsAttackElo, homeTeamExpectedGoalsDefenceElo := nullElo(away.expectedGoalsAttack), nullElo(home.expectedGoalsDefence)
+	expectedHomeTeamExpectedGoalsFor := calcExpectedElo(awayTeamExpectedGoalsDefenceElo, homeTeamExpectedGoalsAttackElo)
+	expectedAwayTeamExpectedGoalsFor := calcExpectedElo(homeTeamExpectedGoalsDefenceElo, awayTeamExpectedGoalsAttackElo)
 
-		err = rows.Scan(&fixtureId, &homeTeamId, &awayTeamId, &homeTeamScore, &awayTeamScore)
-		if err != nil {
-			log.Fatal(err)
+	//all expectations are computed from the ratings before the fixture, so
+	//the updates below can be applied in place
+	home.goal = updateEloForScores(home.goal, expectedHomeTeamScore, normalizedHomeTeamScore, cfg.KFactors["goal"])
+	away.goal = updateEloForScores(away.goal, expectedAwayTeamScore, normalizedAwayTeamScore, cfg.KFactors["goal"])
+
+	home.winner = updateEloForScores(home.winner, expectedHomeTeamWinner, homeTeamWinnerValue, cfg.KFactors["winner"])
+	away.winner = updateEloForScores(away.winner, expectedAwayTeamWinner, awayTeamWinnerValue, cfg.KFactors["winner"])
+
+	if hasBallPossession {
+		home.ballPossession = updateEloForScores(home.ballPossession, expectedHomeTeamBallPossession, normalizedHomeTeamBallPossession, cfg.KFactors["ballPossession"])
+		away.ballPossession = updateEloForScores(away.ballPossession, expectedAwayTeamBallPossession, normalizedAwayTeamBallPossession, cfg.KFactors["ballPossession"])
+	}
+
+	if hasShotsOnTarget {
+		home.totalShots = updateEloForScores(home.totalShots, expectedHomeTeamShotsOnTarget, normalizedHomeTeamShotsOnTarget, cfg.KFactors["totalShots"])
+		away.totalShots = updateEloForScores(away.totalShots, expectedAwayTeamShotsOnTarget, normalizedAwayTeamShotsOnTarget, cfg.KFactors["totalShots"])
+	}
+
+	homeTeamAttackElo, awayTeamDefenceElo := home.attack, away.defence
+	awayTeamAttackElo, homeTeamDefenceElo := away.attack, home.defence
+	home.attack = updateEloForScores(homeTeamAttackElo, expectedHomeTeamAttack, homeTeamAttackScore, cfg.KFactors["attack"])
+	away.defence = updateEloForScores(awayTeamDefenceElo, 1-expectedHomeTeamAttack, 1-homeTeamAttackScore, cfg.KFactors["defence"])
+	away.attack = updateEloForScores(awayTeamAttackElo, expectedAwayTeamAttack, awayTeamAttackScore, cfg.KFactors["attack"])
+	home.defence = updateEloForScores(homeTeamDefenceElo, 1-expectedAwayTeamAttack, 1-awayTeamAttackScore, cfg.KFactors["defence"])
+
+	if hasExpectedGoals {
+		expectedHomeTeamExpectedGoals := calcExpectedElo(awayTeamExpectedGoalsElo, homeTeamExpectedGoalsElo)
+		expectedAwayTeamExpectedGoals := calcExpectedElo(homeTeamExpectedGoalsElo, awayTeamExpectedGoalsElo)
+
+		home.expectedGoals = sql.NullFloat64{Float64: updateEloForScores(homeTeamExpectedGoalsElo, expectedHomeTeamExpectedGoals, homeTeamExpectedGoals, cfg.KFactors["expectedGoals"]), Valid: true}
+		away.expectedGoals = sql.NullFloat64{Float64: updateEloForScores(awayTeamExpectedGoalsElo, expectedAwayTeamExpectedGoals, awayTeamExpectedGoals, cfg.KFactors["expectedGoals"]), Valid: true}
+
+		home.expectedGoalsAttack = sql.NullFloat64{Float64: updateEloForScores(homeTeamExpectedGoalsAttackElo, expectedHomeTeamExpectedGoalsFor, normalizedHomeTeamExpectedGoals, cfg.KFactors["attack"]), Valid: true}
+		away.expectedGoalsDefence = sql.NullFloat64{Float64: updateEloForScores(awayTeamExpectedGoalsDefenceElo, 1-expectedHomeTeamExpectedGoalsFor, 1-normalizedHomeTeamExpectedGoals, cfg.KFactors["defence"]), Valid: true}
+		away.expectedGoalsAttack = sql.NullFloat64{Float64: updateEloForScores(awayTeamExpectedGoalsAttackElo, expectedAwayTeamExpectedGoalsFor, normalizedAwayTeamExpectedGoals, cfg.KFactors["attack"]), Valid: true}
+		home.expectedGoalsDefence = sql.NullFloat64{Float64: updateEloForScores(homeTeamExpectedGoalsDefenceElo, 1-expectedAwayTeamExpectedGoalsFor, 1-normalizedAwayTeamExpectedGoals, cfg.KFactors["defence"]), Valid: true}
+	}
+}
+
+// componentRange returns the range of one raw component over all teams
+func (e *eloEngine) componentRange(component func(r *eloRating) float64) statisticRange {
+	var componentRange statisticRange
+	first := true
+	for _, r := range e.ratings {
+		value := component(r)
+		if first || value < componentRange.min {
+			componentRange.min = value
+		}
+		if first || value > componentRange.max {
+			componentRange.max = value
 		}
+		first = false
+	}
+	return componentRange
+}
 
-		//score get elos
-		homeTeamGoalElo := getCurrentEloFromDB("goalElo", homeTeamId)
-		awayTeamGoalElo := getCurrentEloFromDB("goalElo", awayTeamId)
+// save stores the raw ratings in eloRaw and the ratings rescaled to 1000-2000
+// in elo
+func (e *eloEngine) save(tx *sql.Tx) error {
+	for _, table := range []string{"elo", "eloRaw", "eloRanges"} {
+		if err := checkIdentifiers(table); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+			return fmt.Errorf("failed to clear %s ratings: %v", table, err)
+		}
+	}
 
-		//ball possession gel elo
-		homeTeamBallPossessionElo := getCurrentEloFromDB("ballPossessionElo", homeTeamId)
-		awayTeamBallPossessionElo := getCurrentEloFromDB("ballPossessionElo", awayTeamId)
+	for statistic, r := range e.stats.ranges() {
+		if err := enterDataIntoDB(tx, "eloRanges", []string{"statistic", "minimum", "maximum"}, []interface{}{statistic, r.min, r.max}); err != nil {
+			return err
+		}
+	}
 
-		//shots on target get elo
-		homeTeamShotsOnTargetElo := getCurrentEloFromDB("totalShotsElo", homeTeamId)
-		awayTeamShotsOnTargetElo := getCurrentEloFromDB("totalShotsElo", awayTeamId)
+	columns := []string{"team", "goalElo", "winnerElo", "ballPossessionElo", "totalShotsElo", "expectedGoalsElo", "attackElo", "defenceElo", "expectedGoalsAttackElo", "expectedGoalsDefenceElo"}
+
+	goalRange := e.componentRange(func(r *eloRating) float64 { return r.goal })
+	winnerRange := e.componentRange(func(r *eloRating) float64 { return r.winner })
+	ballPossessionRange := e.componentRange(func(r *eloRating) float64 { return r.ballPossession })
+	totalShotsRange := e.componentRange(func(r *eloRating) float64 { return r.totalShots })
+	attackRange := e.componentRange(func(r *eloRating) float64 { return r.attack })
+	defenceRange := e.componentRange(func(r *eloRating) float64 { return r.defence })
+
+	//teams without any xG coverage keep NULL xG ratings and do not count
+	nullRange := func(component func(r *eloRating) sql.NullFloat64) statisticRange {
+		var componentRange statisticRange
+		first := true
+		for _, r := range e.ratings {
+			value := component(r)
+			if !value.Valid {
+				continue
+			}
+			if first || value.Float64 < componentRange.min {
+				componentRange.min = value.Float64
+			}
+			if first || value.Float64 > componentRange.max {
+				componentRange.max = value.Float64
+			}
+			first = false
+		}
+		return componentRange
+	}
+	expectedGoalsRange := nullRange(func(r *eloRating) sql.NullFloat64 { return r.expectedGoals })
+	expectedGoalsAttackRange := nullRange(func(r *eloRating) sql.NullFloat64 { return r.expectedGoalsAttack })
+	expectedGoalsDefenceRange := nullRange(func(r *eloRating) sql.NullFloat64 { return r.expectedGoalsDefence })
+
+	// a component no fixture has moved, like ball possession for results
+	// imported from football-data.co.uk, leaves every team level
+	rescale := func(componentRange statisticRange, value float64) float64 {
+		if componentRange.max <= componentRange.min {
+			return 1500
+		}
+		return 1000 + normalizeScore(componentRange.max, componentRange.min, value)*1000
+	}
+	rescaleNull := func(componentRange statisticRange, value sql.NullFloat64) sql.NullFloat64 {
+		if !value.Valid || componentRange.max <= componentRange.min {
+			return sql.NullFloat64{}
+		}
+		return sql.NullFloat64{Float64: rescale(componentRange, value.Float64), Valid: true}
+	}
 
-		//winner get elo
-		homeTeamWinnerElo := getCurrentEloFromDB("winnerElo", homeTeamId)
-		awayTeamWinnerElo := getCurrentEloFromDB("winnerElo", awayTeamId)
+	for teamId, r := range e.ratings {
+		err := enterDataIntoDB(tx, "eloRaw", columns, []interface{}{teamId, r.goal, r.winner, r.ballPossession, r.totalShots, r.expectedGoals, r.attack, r.defence, r.expectedGoalsAttack, r.expectedGoalsDefence})
+		if err != nil {
+			return err
+		}
 
-		//score get score
-		//already defined since it is in the fixtures table
+		err = enterDataIntoDB(tx, "elo", columns, []interface{}{
+			teamId,
+			rescale(goalRange, r.goal),
+			rescale(winnerRange, r.winner),
+			rescale(ballPossessionRange, r.ballPossession),
+			rescale(totalShotsRange, r.totalShots),
+			rescaleNull(expectedGoalsRange, r.expectedGoals),
+			rescale(attackRange, r.attack),
+			rescale(defenceRange, r.defence),
+			rescaleNull(expectedGoalsAttackRange, r.expectedGoalsAttack),
+			rescaleNull(expectedGoalsDefenceRange, r.expectedGoalsDefence),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		//ball possession get score
-		homeTeamBallPossession, awayTeamBallPossession, ballPossessionMaxScore, ballPossessionMinScore := getBallPossessionScore(homeTeamId, awayTeamId, fixtureId)
+// Pi-ratings as described by Constantinou and Fenton, "Determining the level
+// of ability of football teams by dynamic ratings based on the relative
+// discrepancies in scores between adversaries"
+const (
+	piLearningRate = 0.035
+	piCatchUpRate  = 0.7
+	piBase         = 10
+	piGoalScale    = 3
+)
 
-		//shots on target get score
-		homeTeamShotsOnTarget, awayTeamShotsOnTarget, shotsOnTargetMaxScore, shotsOnTargetMinScore := getShotsOnTargetScore(homeTeamId, awayTeamId, fixtureId)
+type piRating struct {
+	homeRating float64
+	awayRating float64
+}
 
-		//winner get score
-		//skipped since it is computed with the score values
+type piEngine struct {
+	ratings map[int]*piRating
+}
 
-		//score
-		minScore, maxScore := getMaxMinScore()
-		normalizedHomeTeamScore := normalizeScore(maxScore, minScore, float64(homeTeamScore))
-		normalizedAwayTeamScore := normalizeScore(maxScore, minScore, float64(awayTeamScore))
+func newPiEngine() *piEngine {
+	return &piEngine{ratings: make(map[int]*piRating)}
+}
 
-		//ball possession
-		normalizedHomeTeamBallPossession := normalizeScore(ballPossessionMaxScore, ballPossessionMinScore, homeTeamBallPossession)
-		normalizedAwayTeamBallPossession := normalizeScore(ballPossessionMaxScore, ballPossessionMinScore, awayTeamBallPossession)
+func (e *piEngine) reset() error {
+	e.ratings = make(map[int]*piRating)
+	return nil
+}
 
-		//shots on target
-		normalizedHomeTeamShotsOnTarget := normalizeScore(shotsOnTargetMaxScore, shotsOnTargetMinScore, homeTeamShotsOnTarget)
-		normalizedAwayTeamShotsOnTarget := normalizeScore(shotsOnTargetMaxScore, shotsOnTargetMinScore, awayTeamShotsOnTarget)
+func (e *piEngine) load() error {
+	e.ratings = make(map[int]*piRating)
 
-		//winner
-		//get the normalized value instantly since it is always 1 or 0
-		homeTeamWinnerValue, awayTeamWinnerValue := getWinnerScore(homeTeamScore, awayTeamScore)
+	rows, err := db.Query("SELECT team, homeRating, awayRating FROM piRatings")
+	if err != nil {
+		return fmt.Errorf("failed to load pi ratings: %v", err)
+	}
+	defer rows.Close()
 
-		//score
-		expectedHomeTeamScore := calcExpectedElo(awayTeamGoalElo, homeTeamGoalElo)
-		expectedAwayTeamScore := calcExpectedElo(homeTeamGoalElo, awayTeamGoalElo)
+	for rows.Next() {
+		var teamId int
+		rating := &piRating{}
+		if err := rows.Scan(&teamId, &rating.homeRating, &rating.awayRating); err != nil {
+			return fmt.Errorf("failed to scan pi rating: %v", err)
+		}
+		e.ratings[teamId] = rating
+	}
+	return rows.Err()
+}
+
+func (e *piEngine) get(teamId int) *piRating {
+	rating, ok := e.ratings[teamId]
+	if !ok {
+		rating = &piRating{}
+		e.ratings[teamId] = rating
+	}
+	return rating
+}
 
-		//winner
-		expectedHomeTeamWinner := calcExpectedElo(awayTeamWinnerElo, homeTeamWinnerElo)
-		expectedAwayTeamWinner := calcExpectedElo(homeTeamWinnerElo, awayTeamWinnerElo)
+// piExpectedGoalDifference converts a rating to the goal difference it is
+// worth against an average team
+func piExpectedGoalDifference(rating float64) float64 {
+	goalDifference := math.Pow(piBase, math.Abs(rating)/piGoalScale) - 1
+	if rating < 0 {
+		return -goalDifference
+	}
+	return goalDifference
+}
 
-		//ball possession
-		expectedHomeTeamBallPossession := calcExpectedElo(awayTeamBallPossessionElo, homeTeamBallPossessionElo)
-		expectedAwayTeamBallPossession := calcExpectedElo(homeTeamBallPossessionElo, awayTeamBallPossessionElo)
+func (e *piEngine) predictGoalDifference(homeTeamId int, awayTeamId int) float64 {
+	return piExpectedGoalDifference(e.get(homeTeamId).homeRating) - piExpectedGoalDifference(e.get(awayTeamId).awayRating)
+}
 
-		//shots on target
-		expectedHomeTeamShotsOnTarget := calcExpectedElo(awayTeamShotsOnTargetElo, homeTeamShotsOnTargetElo)
-		expectedAwayTeamShotsOnTarget := calcExpectedElo(homeTeamShotsOnTargetElo, awayTeamShotsOnTargetElo)
+func (e *piEngine) rating(teamId int) float64 {
+	rating := e.get(teamId)
+	return (rating.homeRating + rating.awayRating) / 2
+}
 
-		//score
-		updatedHomeTeamScoreElo := updateEloForScores(homeTeamGoalElo, expectedHomeTeamScore, normalizedHomeTeamScore, 25)
-		updatedAwayTeamScoreElo := updateEloForScores(awayTeamGoalElo, expectedAwayTeamScore, normalizedAwayTeamScore, 25)
+func (e *piEngine) predict(homeTeamId int, awayTeamId int) float64 {
+	goalDifference := e.predictGoalDifference(homeTeamId, awayTeamId)
+	return normalCDF(goalDifference / goalDifferenceDeviation)
+}
 
-		//ball possession
-		updatedHomeTeamBallPossessionElo := updateEloForScores(homeTeamBallPossessionElo, expectedHomeTeamBallPossession, normalizedHomeTeamBallPossession, 25)
-		updatedAwayTeamBallPossessionElo := updateEloForScores(awayTeamBallPossessionElo, expectedAwayTeamBallPossession, normalizedAwayTeamBallPossession, 25)
+func (e *piEngine) processRatingPeriod(fixtures []fixture) {
+	for _, f := range fixtures {
+		predicted := e.predictGoalDifference(f.homeTeamId, f.awayTeamId)
+		observed := float64(f.homeTeamScore - f.awayTeamScore)
 
-		//shots on target
-		updatedHomeTeamShotsOnTargetElo := updateEloForScores(homeTeamShotsOnTargetElo, expectedHomeTeamShotsOnTarget, normalizedHomeTeamShotsOnTarget, 25)
-		updatedAwayTeamShotsOnTargetElo := updateEloForScores(awayTeamShotsOnTargetElo, expectedAwayTeamShotsOnTarget, normalizedAwayTeamShotsOnTarget, 25)
+		// large errors count for less than small ones
+		errorWeight := piGoalScale * math.Log10(1+math.Abs(observed-predicted))
+		if observed < predicted {
+			errorWeight = -errorWeight
+		}
 
-		//winner
-		updatedHomeTeamWinnerElo := updateEloForScores(homeTeamWinnerElo, expectedHomeTeamWinner, homeTeamWinnerValue, 25)
-		updatedAwayTeamWinnerElo := updateEloForScores(awayTeamWinnerElo, expectedAwayTeamWinner, awayTeamWinnerValue, 25)
+		home := e.get(f.homeTeamId)
+		away := e.get(f.awayTeamId)
 
-		//score
-		updateEloForTeam("goalElo", homeTeamId, updatedHomeTeamScoreElo)
-		updateEloForTeam("goalElo", awayTeamId, updatedAwayTeamScoreElo)
+		homeChange := errorWeight * piLearningRate
+		home.homeRating += homeChange
+		home.awayRating += homeChange * piCatchUpRate
 
-		//ball possession
-		updateEloForTeam("ballPossessionElo", homeTeamId, updatedHomeTeamBallPossessionElo)
-		updateEloForTeam("ballPossessionElo", awayTeamId, updatedAwayTeamBallPossessionElo)
+		awayChange := -errorWeight * piLearningRate
+		away.awayRating += awayChange
+		away.homeRating += awayChange * piCatchUpRate
+	}
+}
 
-		//shots on target
-		updateEloForTeam("totalShotsElo", homeTeamId, updatedHomeTeamShotsOnTargetElo)
-		updateEloForTeam("totalShotsElo", awayTeamId, updatedAwayTeamShotsOnTargetElo)
+func (e *piEngine) save(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM piRatings"); err != nil {
+		return fmt.Errorf("failed to clear pi ratings: %v", err)
+	}
 
-		//winner
-		updateEloForTeam("winnerElo", homeTeamId, updatedHomeTeamWinnerElo)
-		updateEloForTeam("winnerElo", awayTeamId, updatedAwayTeamWinnerElo)
+	for teamId, rating := range e.ratings {
+		err := enterDataIntoDB(tx, "piRatings", []string{"team", "homeRating", "awayRating"}, []interface{}{teamId, rating.homeRating, rating.awayRating})
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// Glicko-2 as described by Glickman, "Example of the Glicko-2 system"
+const (
+	glickoScale             = 173.7178
+	glickoDefaultRating     = 1500
+	glickoDefaultDeviation  = 350
+	glickoDefaultVolatility = 0.06
+	glickoTau               = 0.5
+	glickoEpsilon           = 0.000001
+)
+
+type glickoRating struct {
+	rating     float64
+	deviation  float64
+	volatility float64
 }
 
-//1: get elos
-//2: get values
-//3: normalize values
-//4: calc expected elos
-//5: calac updated elos
-//6: update elos
-//scoreElo, winnerElo, ballPossessionElo, shotsOnTargetElo
+type glickoEngine struct {
+	ratings map[int]*glickoRating
+}
 
-func normalizeEloValues() {
-	query := "SELECT MAX(goalElo), MAX(winnerElo), MAX(ballPossessionElo), MAX(totalShotsElo), MIN(goalElo), MIN(winnerElo), MIN(ballPossessionElo), MIN(totalShotsElo) FROM elo"
+func newGlickoEngine() *glickoEngine {
+	return &glickoEngine{ratings: make(map[int]*glickoRating)}
+}
 
-	var maxGoalElo float64
-	var maxWinnerElo float64
-	var maxBallPossessionElo float64
-	var maxShotsOnTargetElo float64
-	var minGoalElo float64
-	var minWinnerElo float64
-	var minBallPossessionElo float64
-	var minShotsOnTargetElo float64
+func (e *glickoEngine) reset() error {
+	e.ratings = make(map[int]*glickoRating)
+	return nil
+}
 
-	row := db.QueryRow(query)
-	row.Scan(&maxGoalElo, &maxWinnerElo, &maxBallPossessionElo, &maxShotsOnTargetElo, &minGoalElo, &minWinnerElo, &minBallPossessionElo, &minShotsOnTargetElo)
+func (e *glickoEngine) load() error {
+	e.ratings = make(map[int]*glickoRating)
 
-	query = "SELECT * FROM elo"
-	rows, err := db.Query(query)
+	rows, err := db.Query("SELECT team, rating, deviation, volatility FROM glicko")
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to load glicko ratings: %v", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var teamId int
-		var goalElo float64
-		var winnerElo float64
-		var ballPossessionElo float64
-		var totalShotsElo float64
+		rating := &glickoRating{}
+		if err := rows.Scan(&teamId, &rating.rating, &rating.deviation, &rating.volatility); err != nil {
+			return fmt.Errorf("failed to scan glicko rating: %v", err)
+		}
+		e.ratings[teamId] = rating
+	}
+	return rows.Err()
+}
+
+func (e *glickoEngine) get(teamId int) *glickoRating {
+	rating, ok := e.ratings[teamId]
+	if !ok {
+		rating = &glickoRating{rating: glickoDefaultRating, deviation: glickoDefaultDeviation, volatility: glickoDefaultVolatility}
+		e.ratings[teamId] = rating
+	}
+	return rating
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glickoE(mu float64, opponentMu float64, opponentPhi float64) float64 {
+	return 1 / (1 + math.Exp(-glickoG(opponentPhi)*(mu-opponentMu)))
+}
+
+func (e *glickoEngine) rating(teamId int) float64 {
+	return e.get(teamId).rating
+}
+
+func (e *glickoEngine) predict(homeTeamId int, awayTeamId int) float64 {
+	home := e.get(homeTeamId)
+	away := e.get(awayTeamId)
+
+	mu := (home.rating - glickoDefaultRating) / glickoScale
+	opponentMu := (away.rating - glickoDefaultRating) / glickoScale
+	combinedPhi := math.Sqrt(home.deviation*home.deviation+away.deviation*away.deviation) / glickoScale
+
+	return glickoE(mu, opponentMu, combinedPhi)
+}
+
+type glickoResult struct {
+	opponentId int
+	score      float64
+}
+
+func (e *glickoEngine) processRatingPeriod(fixtures []fixture) {
+	results := make(map[int][]glickoResult)
+	for _, f := range fixtures {
+		homeScore := 0.5
+		if f.homeTeamScore > f.awayTeamScore {
+			homeScore = 1
+		} else if f.homeTeamScore < f.awayTeamScore {
+			homeScore = 0
+		}
+
+		results[f.homeTeamId] = append(results[f.homeTeamId], glickoResult{opponentId: f.awayTeamId, score: homeScore})
+		results[f.awayTeamId] = append(results[f.awayTeamId], glickoResult{opponentId: f.homeTeamId, score: 1 - homeScore})
+		e.get(f.homeTeamId)
+		e.get(f.awayTeamId)
+	}
+
+	// compute every update from the pre-period ratings before applying any
+	updated := make(map[int]glickoRating)
+	for teamId, rating := range e.ratings {
+		updated[teamId] = e.updateRating(*rating, results[teamId])
+	}
+	for teamId, rating := range updated {
+		*e.ratings[teamId] = rating
+	}
+}
+
+func (e *glickoEngine) updateRating(rating glickoRating, results []glickoResult) glickoRating {
+	mu := (rating.rating - glickoDefaultRating) / glickoScale
+	phi := rating.deviation / glickoScale
+	sigma := rating.volatility
+
+	// a team that did not play only becomes less certain
+	if len(results) == 0 {
+		phi = math.Sqrt(phi*phi + sigma*sigma)
+		return glickoRating{rating: rating.rating, deviation: phi * glickoScale, volatility: sigma}
+	}
+
+	var vInverse float64
+	var deltaSum float64
+	for _, result := range results {
+		opponent := e.ratings[result.opponentId]
+		opponentMu := (opponent.rating - glickoDefaultRating) / glickoScale
+		opponentPhi := opponent.deviation / glickoScale
+
+		g := glickoG(opponentPhi)
+		expected := glickoE(mu, opponentMu, opponentPhi)
+		vInverse += g * g * expected * (1 - expected)
+		deltaSum += g * (result.score - expected)
+	}
+	v := 1 / vInverse
+	delta := v * deltaSum
+
+	// new volatility via the Illinois algorithm
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		return ex*(delta*delta-phi*phi-v-ex)/(2*math.Pow(phi*phi+v+ex, 2)) - (x-a)/(glickoTau*glickoTau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
 
-		err = rows.Scan(&teamId, &goalElo, &winnerElo, &totalShotsElo, &ballPossessionElo)
+	fA := f(A)
+	fB := f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A = B
+			fA = fB
+		} else {
+			fA = fA / 2
+		}
+		B = C
+		fB = fC
+	}
+	newSigma := math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + newSigma*newSigma)
+	newPhi := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	newMu := mu + newPhi*newPhi*deltaSum
+
+	return glickoRating{
+		rating:     newMu*glickoScale + glickoDefaultRating,
+		deviation:  newPhi * glickoScale,
+		volatility: newSigma,
+	}
+}
+
+func (e *glickoEngine) save(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM glicko"); err != nil {
+		return fmt.Errorf("failed to clear glicko ratings: %v", err)
+	}
+
+	for teamId, rating := range e.ratings {
+		err := enterDataIntoDB(tx, "glicko", []string{"team", "rating", "deviation", "volatility"}, []interface{}{teamId, rating.rating, rating.deviation, rating.volatility})
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+	}
+	return nil
+}
 
-		normalizedGoalElo := normalizeScore(maxGoalElo, minGoalElo, goalElo)
-		normalizedWinnerElo := normalizeScore(maxWinnerElo, minWinnerElo, winnerElo)
-		normalizedBallPossessionElo := normalizeScore(maxBallPossessionElo, minBallPossessionElo, ballPossessionElo)
-		normalizedTotalShotsElo := normalizeScore(maxShotsOnTargetElo, minShotsOnTargetElo, totalShotsElo)
+func newRatingEngine(name string) (ratingEngine, error) {
+	switch name {
+	case "elo":
+		return &eloEngine{expectedGoals: true}, nil
+	case "glicko2":
+		return newGlickoEngine(), nil
+	case "pi":
+		return newPiEngine(), nil
+	}
+	return nil, fmt.Errorf("unknown rating engine: %s", name)
+}
 
-		normalizedGoalElo = 1000 + normalizedGoalElo*1000
-		normalizedWinnerElo = 1000 + normalizedWinnerElo*1000
-		normalizedBallPossessionElo = 1000 + normalizedBallPossessionElo*1000
-		normalizedTotalShotsElo = 1000 + normalizedTotalShotsElo*1000
+// compareRatingEngines backtests every engine on the same fixtures. Elo without
+// xG runs first so the elo table ends up with the full model. Every engine is
+// rebuilt, since both Elo variants share the elo table.
+func compareRatingEngines() {
+	engines := []struct {
+		name   string
+		engine ratingEngine
+	}{
+		{"elo without xG", &eloEngine{expectedGoals: false}},
+		{"elo", &eloEngine{expectedGoals: true}},
+		{"glicko2", newGlickoEngine()},
+		{"pi", newPiEngine()},
+	}
 
-		updateEloForTeam("goalElo", teamId, normalizedGoalElo)
-		updateEloForTeam("winnerElo", teamId, normalizedWinnerElo)
-		updateEloForTeam("ballPossessionElo", teamId, normalizedBallPossessionElo)
-		updateEloForTeam("totalShotsElo", teamId, normalizedTotalShotsElo)
+	for _, e := range engines {
+		result, err := runRatingEngine(e.name, e.engine, true)
+		if err != nil {
+			log.Fatalf("Failed to run %s: %v", e.name, err)
+		}
+		if result.fixtures > 0 {
+			fmt.Printf("%-15s log-loss %.4f over %d fixtures", e.name, result.logLoss/float64(result.fixtures), result.fixtures)
+			if result.poissonFixtures > 0 {
+				fmt.Printf(", Poisson 1X2 log-loss %.4f", result.poissonLogLoss/float64(result.poissonFixtures))
+			}
+			fmt.Println()
+		}
 	}
 }
 
 func main() {
-	err := initDB()
+	engineName := flag.String("engine", "elo", "rating engine to run: elo, glicko2 or pi")
+	compare := flag.Bool("compare", false, "run every engine and compare their backtest log-loss")
+	rebuild := flag.Bool("rebuild", false, "replay every fixture from scratch instead of only the new ones, needed after changing parameters")
+	flag.Parse()
+
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	err := initDB(cfg.databaseConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer closeDB()
 
-	query := "DELETE FROM elo"
-	_, err = db.Exec(query)
+	if err := createTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+
+	if *compare {
+		compareRatingEngines()
+		return
+	}
+
+	engine, err := newRatingEngine(*engineName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	result, err := runRatingEngine(*engineName, engine, *rebuild)
 	if err != nil {
-		log.Printf("Error deleting data: %v", err)
+		log.Fatalf("Failed to run %s: %v", *engineName, err)
 	}
 
-	calcEloForScores()
-	normalizeEloValues()
+	if result.fixtures == 0 {
+		fmt.Println("No new fixtures")
+		return
+	}
+	fmt.Printf("Applied %d fixtures\n", result.fixtures)
+	fmt.Printf("Log-loss: %.4f\n", result.logLoss/float64(result.fixtures))
+	if result.poissonFixtures > 0 {
+		fmt.Printf("Poisson 1X2 log-loss: %.4f\n", result.poissonLogLoss/float64(result.poissonFixtures))
+	}
 }
@@ -0,0 +1,182 @@
+package main
+
+// The programs of this repository share package main, so the tests are run
+// together with the files of this program:
+//
+//	go test createEloRanking_test.go createEloRanking.go config.go database.go teams.go
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// seedDatabase opens a fresh SQLite database and runs queries on it
+func seedDatabase(t *testing.T, queries ...string) {
+	t.Helper()
+
+	database := databaseConfig{Database: filepath.Join(t.TempDir(), "FootballTracker.db"), BusyTimeout: 1000}
+	if err := initDB(database); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeDB)
+
+	if err := createTables(); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("failed to seed database: %v", err)
+		}
+	}
+}
+
+func periodIDs(periods [][]fixture) [][]int {
+	var ids [][]int
+	for _, period := range periods {
+		var fixtureIDs []int
+		for _, f := range period {
+			fixtureIDs = append(fixtureIDs, f.fixtureId)
+		}
+		ids = append(ids, fixtureIDs)
+	}
+	return ids
+}
+
+func TestLoadRatingPeriods(t *testing.T) {
+	// fixture 3 of round 1 is postponed until after round 2, fixtures 7 and 8
+	// have no round and fixture 9 has no date either
+	seedDatabase(t, "INSERT INTO fixtures (fixtureId, homeTeam, awayTeam, homeTeamScore, awayTeamScore, round, date, season) VALUES "+
+		"(1, 1, 2, 1, 0, 'Regular Season - 1', '2026-08-01T18:00:00+00:00', '2026'), "+
+		"(2, 3, 4, 1, 1, 'Regular Season - 1', '2026-08-02T18:00:00+00:00', '2026'), "+
+		"(3, 5, 6, 0, 2, 'Regular Season - 1', '2026-09-01T18:00:00+00:00', '2026'), "+
+		"(4, 2, 3, 2, 2, 'Regular Season - 2', '2026-08-08T18:00:00+00:00', '2026'), "+
+		"(5, 4, 1, 0, 1, 'Regular Season - 2', '2026-08-09T18:00:00+00:00', '2026'), "+
+		"(6, 6, 5, 3, 0, 'Regular Season - 1', '2027-08-01T18:00:00+00:00', '2027'), "+
+		"(7, 1, 3, 1, 0, NULL, '2027-08-05T12:00:00+00:00', '2027'), "+
+		"(8, 2, 4, 1, 0, NULL, '2027-08-05T18:00:00+00:00', '2027'), "+
+		"(9, 5, 1, 1, 0, NULL, NULL, '2027')")
+
+	periods, err := loadRatingPeriods("elo", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]int{{1, 2}, {4, 5}, {3}, {6}, {7, 8}, {9}}
+	if got := periodIDs(periods); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got periods %v, want %v", got, want)
+	}
+}
+
+// playRound stores a round of four teams with goals and shots, the most shots
+// in the round being maxShots
+func playRound(t *testing.T, round int, maxShots float64) {
+	t.Helper()
+
+	for i, teams := range [][2]int{{1, 2}, {3, 4}} {
+		fixtureID := round*10 + i
+		home, away := teams[0], teams[1]
+		if round%2 == 0 {
+			home, away = away, home
+		}
+		_, err := db.Exec("INSERT INTO fixtures (fixtureId, homeTeam, awayTeam, homeTeamScore, awayTeamScore, round, date, season) VALUES (?, ?, ?, ?, ?, ?, ?, '2026')",
+			fixtureID, home, away, (round+i)%3, i, fmt.Sprintf("Regular Season - %d", round), fmt.Sprintf("2026-08-%02dT18:00:00+00:00", round*7+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for team, shots := range map[int]float64{home: maxShots - float64(i), away: 5 + float64(round%2)} {
+			for table, value := range map[string]float64{"totalShots": shots, "ballPossession": 30 + shots*2, "expectedGoals": shots / 8} {
+				if _, err := db.Exec(fmt.Sprintf("INSERT INTO %s (fixtureId, team, %s) VALUES (?, ?, ?)", table, table), fixtureID, team, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+// eloRatings reads the stored Elo ratings, raw and normalized, by team
+func eloRatings(t *testing.T) map[string][]sql.NullFloat64 {
+	t.Helper()
+
+	ratings := make(map[string][]sql.NullFloat64)
+	for _, table := range []string{"elo", "eloRaw"} {
+		rows, err := db.Query(fmt.Sprintf("SELECT team, goalElo, winnerElo, ballPossessionElo, totalShotsElo, expectedGoalsElo, attackElo, defenceElo, expectedGoalsAttackElo, expectedGoalsDefenceElo FROM %s", table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var team int
+			values := make([]sql.NullFloat64, 9)
+			if err := rows.Scan(&team, &values[0], &values[1], &values[2], &values[3], &values[4], &values[5], &values[6], &values[7], &values[8]); err != nil {
+				t.Fatal(err)
+			}
+			ratings[fmt.Sprintf("%s %d", table, team)] = values
+		}
+		rows.Close()
+	}
+	return ratings
+}
+
+// runElo runs the Elo engine and returns how many fixtures it applied
+func runElo(t *testing.T, rebuild bool) int {
+	t.Helper()
+
+	engine, err := newRatingEngine("elo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := runRatingEngine("elo", engine, rebuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.fixtures
+}
+
+// checkRebuild rebuilds the Elo ratings and compares them with the ratings
+// of the incremental run before
+func checkRebuild(t *testing.T) {
+	t.Helper()
+
+	incremental := eloRatings(t)
+	runElo(t, true)
+	rebuilt := eloRatings(t)
+
+	if len(incremental) != len(rebuilt) {
+		t.Fatalf("%d ratings after the incremental run, %d after the rebuild", len(incremental), len(rebuilt))
+	}
+	for key, values := range rebuilt {
+		for i, value := range values {
+			other := incremental[key][i]
+			if value.Valid != other.Valid || math.Abs(value.Float64-other.Float64) > 1e-9 {
+				t.Errorf("%s column %d: %v incrementally, %v rebuilt", key, i, other, value)
+			}
+		}
+	}
+}
+
+func TestIncrementalMatchesRebuild(t *testing.T) {
+	seedDatabase(t)
+	playRound(t, 1, 12)
+	playRound(t, 2, 10)
+	if applied := runElo(t, false); applied != 4 {
+		t.Fatalf("first run applied %d fixtures, want 4", applied)
+	}
+
+	// a round within the ranges of the statistics continues from the
+	// stored ratings
+	playRound(t, 3, 11)
+	if applied := runElo(t, false); applied != 2 {
+		t.Errorf("applied %d fixtures, want only the 2 new ones", applied)
+	}
+	checkRebuild(t)
+
+	// more shots than ever before change the scale of the shot Elo, so the
+	// stored ratings are replayed
+	playRound(t, 4, 20)
+	if applied := runElo(t, false); applied != 8 {
+		t.Errorf("applied %d fixtures, want all 8 after the range changed", applied)
+	}
+	checkRebuild(t)
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cfg holds the settings of this program, which has none besides the
+// database
+var cfg = defaultDatabaseConfig
+
+// cronField is the set of values one field of a cron expression matches
+type cronField map[int]bool
+
+// parseCronField understands *, single values, ranges (1-5), lists (1,3,5)
+// and steps (*/15, 0-30/10)
+func parseCronField(field string, low int, high int) (cronField, error) {
+	values := cronField{}
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		from, to := low, high
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid range %q", part)
+				}
+			}
+		}
+		if from < low || to > high || from > to {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, low, high)
+		}
+
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// cronSchedule is a standard five field cron expression:
+// minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	expression string
+	minute     cronField
+	hour       cronField
+	dayOfMonth cronField
+	month      cronField
+	dayOfWeek  cronField
+	// like in cron, when both day fields are restricted a day matching either
+	// of them is enough, so "0 9 1 * 1" runs on the 1st and on every Monday
+	eitherDay bool
+}
+
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields, got %d", expression, len(fields))
+	}
+
+	// day-of-week 7 is Sunday like 0
+	limits := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := make([]cronField, 5)
+	for i, field := range fields {
+		values, err := parseCronField(field, limits[i][0], limits[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %v", expression, err)
+		}
+		parsed[i] = values
+	}
+	if parsed[4][7] {
+		delete(parsed[4], 7)
+		parsed[4][0] = true
+	}
+
+	return &cronSchedule{
+		expression: expression,
+		minute:     parsed[0],
+		hour:       parsed[1],
+		dayOfMonth: parsed[2],
+		month:      parsed[3],
+		dayOfWeek:  parsed[4],
+		eitherDay:  !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	if c.eitherDay {
+		return c.dayOfMonth[t.Day()] || c.dayOfWeek[int(t.Weekday())]
+	}
+	return c.dayOfMonth[t.Day()] && c.dayOfWeek[int(t.Weekday())]
+}
+
+// next returns the first full minute after t that matches the schedule
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// every schedule matches at least once within four years (29 February)
+	limit := t.AddDate(4, 0, 1)
+	for t.Before(limit) {
+		if c.month[int(t.Month())] && c.matchesDay(t) && c.hour[t.Hour()] && c.minute[t.Minute()] {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// stepResult is the outcome of running one of the programs
+type stepResult struct {
+	Name     string    `json:"name"`
+	Command  string    `json:"command"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	ExitCode int       `json:"exitCode"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output"`
+}
+
+// pipelineRun is one pass of ingest, ratings and predictions
+type pipelineRun struct {
+	Started     time.Time    `json:"started"`
+	Finished    time.Time    `json:"finished"`
+	Success     bool         `json:"success"`
+	NewFixtures int          `json:"newFixtures"`
+	Steps       []stepResult `json:"steps"`
+}
+
+// daemon runs the pipeline on its schedule and remembers how the runs went
+// for the status endpoints
+type daemon struct {
+	schedule    *cronSchedule
+	binDir      string
+	engines     []string
+	predictions string
+	// fetch lineups, player statistics and injuries in the ingest step
+	lineups bool
+
+	mu          sync.Mutex
+	running     bool
+	nextRun     time.Time
+	lastRun     *pipelineRun
+	lastSuccess time.Time
+}
+
+// outputTail keeps the end of a program's output, which is where errors are
+const outputTail = 4000
+
+// command returns how to start one of the programs: the built binary when a
+// bin directory is set, go run otherwise. go run starts the program as a
+// child of its own, so each command runs in a process group of its own and a
+// timeout kills the whole group instead of only the go command.
+func (d *daemon) command(ctx context.Context, program string, args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if d.binDir != "" {
+		cmd = exec.CommandContext(ctx, filepath.Join(d.binDir, program), args...)
+	} else {
+		// every program is built together with the shared config, database and
+		// team name files
+		files := []string{"run", program + ".go", "config.go", "database.go", "teams.go"}
+		cmd = exec.CommandContext(ctx, "go", append(files, args...)...)
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd
+}
+
+func (d *daemon) runStep(ctx context.Context, name string, cmd *exec.Cmd) (stepResult, []byte) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	result := stepResult{
+		Name:    name,
+		Command: strings.Join(cmd.Args, " "),
+		Started: time.Now(),
+	}
+	log.Printf("Running %s: %s", name, result.Command)
+
+	err := cmd.Run()
+	result.Duration = time.Since(result.Started).Round(time.Millisecond).String()
+
+	output := stdout.String() + stderr.String()
+	if len(output) > outputTail {
+		output = output[len(output)-outputTail:]
+	}
+	result.Output = output
+
+	if err != nil {
+		result.Error = err.Error()
+		result.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		log.Printf("%s failed: %v", name, err)
+	}
+
+	return result, stdout.Bytes()
+}
+
+func countFixtures() (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM fixtures").Scan(&count)
+	return count, err
+}
+
+// writeFileAtomic replaces path in one step so readers never see half a file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runPipeline ingests new fixtures, updates the ratings of every engine and
+// regenerates the predictions for upcoming fixtures, which also change with
+// new odds and kickoff times. The ratings run every time even without new
+// fixtures: createEloRanking only applies what it has not applied yet, which
+// also covers a run that failed before and fixtures replaced by an import.
+func (d *daemon) runPipeline(ctx context.Context) {
+	d.mu.Lock()
+	if d.running {
+		d.mu.Unlock()
+		log.Println("Previous run is still in progress, skipping")
+		return
+	}
+	d.running = true
+	d.mu.Unlock()
+
+	run := &pipelineRun{Started: time.Now(), Success: true}
+	defer func() {
+		run.Finished = time.Now()
+
+		d.mu.Lock()
+		d.running = false
+		d.lastRun = run
+		if run.Success {
+			d.lastSuccess = run.Finished
+		}
+		d.mu.Unlock()
+
+		log.Printf("Run finished after %s, success: %v, new fixtures: %d",
+			run.Finished.Sub(run.Started).Round(time.Second), run.Success, run.NewFixtures)
+	}()
+
+	step := func(name string, cmd *exec.Cmd) ([]byte, bool) {
+		result, stdout := d.runStep(ctx, name, cmd)
+		run.Steps = append(run.Steps, result)
+		if result.Error != "" {
+			run.Success = false
+			return nil, false
+		}
+		return stdout, true
+	}
+
+	before, err := countFixtures()
+	if err != nil {
+		// the first ingest creates the tables
+		before = 0
+	}
+
+	var ingestArgs []string
+	if d.lineups {
+		ingestArgs = append(ingestArgs, "-lineups")
+	}
+	if _, ok := step("ingest", d.command(ctx, "getDataFromAPI", ingestArgs...)); !ok {
+		return
+	}
+
+	after, err := countFixtures()
+	if err != nil {
+		run.Success = false
+		run.Steps = append(run.Steps, stepResult{Name: "count fixtures", Started: time.Now(), ExitCode: -1, Error: err.Error()})
+		return
+	}
+	run.NewFixtures = after - before
+
+	for _, engine := range d.engines {
+		if _, ok := step("ratings "+engine, d.command(ctx, "createEloRanking", "-engine", engine)); !ok {
+			return
+		}
+	}
+
+	predictions, ok := step("predictions", d.command(ctx, "generateChances", "-format", "json", "upcoming"))
+	if !ok {
+		return
+	}
+	if err := writeFileAtomic(d.predictions, predictions); err != nil {
+		run.Success = false
+		run.Steps = append(run.Steps, stepResult{Name: "write predictions", Started: time.Now(), ExitCode: -1, Error: err.Error()})
+	}
+}
+
+// loop runs the pipeline at every scheduled minute until ctx is cancelled
+func (d *daemon) loop(ctx context.Context) {
+	for {
+		next := d.schedule.next(time.Now())
+		if next.IsZero() {
+			log.Printf("Schedule %q never matches, stopping", d.schedule.expression)
+			return
+		}
+
+		d.mu.Lock()
+		d.nextRun = next
+		d.mu.Unlock()
+		log.Printf("Next run at %s", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			d.runPipeline(ctx)
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+type daemonStatus struct {
+	Schedule    string       `json:"schedule"`
+	Running     bool         `json:"running"`
+	NextRun     time.Time    `json:"nextRun"`
+	LastSuccess *time.Time   `json:"lastSuccess"`
+	LastRun     *pipelineRun `json:"lastRun"`
+}
+
+func (d *daemon) status() daemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := daemonStatus{
+		Schedule: d.schedule.expression,
+		Running:  d.running,
+		NextRun:  d.nextRun,
+		LastRun:  d.lastRun,
+	}
+	if !d.lastSuccess.IsZero() {
+		lastSuccess := d.lastSuccess
+		s.LastSuccess = &lastSuccess
+	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// handleHealth answers 200 while the last run succeeded (or none has finished
+// yet) and 503 once it failed, so a supervisor can alert on it
+func (d *daemon) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s := d.status()
+	if s.LastRun != nil && !s.LastRun.Success {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "failing", "lastSuccess": s.LastSuccess})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "lastSuccess": s.LastSuccess})
+}
+
+func (d *daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.status())
+}
+
+// handleRun starts a run outside the schedule
+func (d *daemon) handleRun(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.status().Running {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": "a run is already in progress"})
+			return
+		}
+		go d.runPipeline(ctx)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "started"})
+	}
+}
+
+func main() {
+	schedule := flag.String("schedule", "0 */2 * * *", "cron expression (minute hour day-of-month month day-of-week) for runs")
+	addr := flag.String("addr", ":8081", "address of the health and status endpoints")
+	binDir := flag.String("bin", "", "directory with the built programs, go run is used if empty")
+	engines := flag.String("engines", "elo,glicko2,pi", "comma separated rating engines to update")
+	predictions := flag.String("predictions", "upcoming.json", "file the upcoming predictions are written to")
+	lineups := flag.Bool("lineups", false, "run getDataFromAPI with -lineups, which costs extra API requests")
+	once := flag.Bool("once", false, "run the pipeline once and exit")
+	flag.Parse()
+
+	cron, err := parseCronSchedule(*schedule)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := readConfig(&cfg, &cfg); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := initDB(cfg); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer closeDB()
+
+	d := &daemon{
+		schedule:    cron,
+		binDir:      *binDir,
+		engines:     strings.Split(*engines, ","),
+		predictions: *predictions,
+		lineups:     *lineups,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		d.runPipeline(ctx)
+		if !d.status().LastRun.Success {
+			os.Exit(1)
+		}
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", d.handleHealth)
+	mux.HandleFunc("GET /status", d.handleStatus)
+	mux.HandleFunc("POST /run", d.handleRun(ctx))
+	server := &http.Server{Addr: *addr, Handler: mux}
+
+	go func() {
+		log.Printf("Serving health and status on %s", *addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	d.loop(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+}
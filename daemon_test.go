@@ -0,0 +1,58 @@
+package main
+
+// The programs of this repository share package main, so the tests are run
+// together with the files of this program:
+//
+//	go test daemon_test.go daemon.go config.go database.go teams.go
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCronSundayAsSeven(t *testing.T) {
+	for _, expression := range []string{"0 9 * * 7", "0 9 * * 0", "0 9 * * 5-7"} {
+		schedule, err := parseCronSchedule(expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// after 9 on Saturday 17 October 2026 the next run is on Sunday
+		next := schedule.next(time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC))
+		if want := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC); !next.Equal(want) {
+			t.Errorf("%s: next run %v, want %v", expression, next, want)
+		}
+		if schedule.dayOfWeek[7] || !schedule.dayOfWeek[0] {
+			t.Errorf("%s: days of week %v", expression, schedule.dayOfWeek)
+		}
+	}
+
+	if _, err := parseCronSchedule("0 9 * * 8"); err == nil {
+		t.Error("day of week 8 accepted")
+	}
+}
+
+func TestCommandTimeoutKillsChildren(t *testing.T) {
+	// like go run, the program leaves a child running, which keeps the
+	// output open until it is killed too
+	binDir := t.TempDir()
+	script := "#!/bin/sh\nsleep 30 &\nwait\n"
+	if err := os.WriteFile(filepath.Join(binDir, "createEloRanking"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	d := &daemon{binDir: binDir}
+	started := time.Now()
+	result, _ := d.runStep(ctx, "ratings", d.command(ctx, "createEloRanking"))
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("the step took %v after its timeout", elapsed)
+	}
+	if result.ExitCode == 0 {
+		t.Errorf("the step did not fail: %+v", result)
+	}
+}
@@ -0,0 +1,193 @@
+const svgNS = "http://www.w3.org/2000/svg";
+
+let selectedTeam = null;
+
+function engine() {
+	return document.getElementById("engine").value;
+}
+
+async function getJSON(url) {
+	const response = await fetch(url);
+	const body = await response.json();
+	if (!response.ok) {
+		throw new Error(body.error || response.statusText);
+	}
+	return body;
+}
+
+function svgElement(name, attributes, text) {
+	const element = document.createElementNS(svgNS, name);
+	for (const [key, value] of Object.entries(attributes)) {
+		element.setAttribute(key, value);
+	}
+	if (text !== undefined) {
+		element.textContent = text;
+	}
+	return element;
+}
+
+function cell(row, text) {
+	const td = document.createElement("td");
+	td.textContent = text;
+	row.appendChild(td);
+}
+
+function rating(value) {
+	return value === null || value === undefined ? "-" : value.toFixed(0);
+}
+
+function percent(value) {
+	return (value * 100).toFixed(1) + "%";
+}
+
+async function loadRatings() {
+	const body = document.querySelector("#ratings tbody");
+	body.replaceChildren();
+
+	const ratings = await getJSON(`/ratings?engine=${engine()}`);
+	ratings.forEach((r, i) => {
+		const row = document.createElement("tr");
+		row.dataset.team = r.teamId;
+		if (r.teamId === selectedTeam) {
+			row.classList.add("selected");
+		}
+
+		cell(row, i + 1);
+		cell(row, r.team || r.teamId);
+		// pi-ratings are in goals
+		cell(row, r.engine === "pi" ? r.rating.toFixed(2) : rating(r.rating));
+		cell(row, rating(r.goalElo));
+		cell(row, rating(r.winnerElo));
+		cell(row, rating(r.expectedGoalsElo));
+		cell(row, rating(r.totalShotsElo));
+		cell(row, rating(r.ballPossessionElo));
+		cell(row, rating(r.attackElo));
+		cell(row, rating(r.defenceElo));
+
+		row.addEventListener("click", () => {
+			selectedTeam = r.teamId;
+			document.querySelectorAll("#ratings tbody tr").forEach((tr) => tr.classList.remove("selected"));
+			row.classList.add("selected");
+			document.getElementById("history-title").textContent = "Rating history: " + (r.team || r.teamId);
+			loadHistory();
+		});
+
+		body.appendChild(row);
+	});
+
+	if (selectedTeam === null && ratings.length > 0) {
+		body.firstChild.click();
+	}
+}
+
+async function loadHistory() {
+	const svg = document.getElementById("history");
+	svg.replaceChildren();
+	if (selectedTeam === null) {
+		return;
+	}
+
+	const history = await getJSON(`/ratings/${selectedTeam}/history?engine=${engine()}`);
+	if (history.length === 0) {
+		svg.appendChild(svgElement("text", { x: 300, y: 120, "text-anchor": "middle" }, "No history for this engine yet"));
+		return;
+	}
+
+	const width = 600;
+	const height = 240;
+	const margin = 40;
+	const values = history.map((h) => h.rating);
+	const min = Math.min(...values);
+	const max = Math.max(...values);
+	const span = max - min || 1;
+
+	const x = (i) => margin + (i / Math.max(history.length - 1, 1)) * (width - 2 * margin);
+	const y = (v) => height - margin - ((v - min) / span) * (height - 2 * margin);
+
+	svg.appendChild(svgElement("line", { class: "axis", x1: margin, y1: height - margin, x2: width - margin, y2: height - margin }));
+	svg.appendChild(svgElement("line", { class: "axis", x1: margin, y1: margin, x2: margin, y2: height - margin }));
+	svg.appendChild(svgElement("text", { x: margin - 4, y: y(max) + 3, "text-anchor": "end" }, max.toFixed(0)));
+	svg.appendChild(svgElement("text", { x: margin - 4, y: y(min) + 3, "text-anchor": "end" }, min.toFixed(0)));
+	svg.appendChild(svgElement("text", { x: margin, y: height - margin + 14 }, history[0].date.slice(0, 10)));
+	svg.appendChild(svgElement("text", { x: width - margin, y: height - margin + 14, "text-anchor": "end" }, history[history.length - 1].date.slice(0, 10)));
+
+	const points = history.map((h, i) => `${x(i)},${y(h.rating)}`).join(" ");
+	svg.appendChild(svgElement("polyline", { class: "line", points: points }));
+}
+
+async function loadUpcoming() {
+	const body = document.querySelector("#upcoming tbody");
+	body.replaceChildren();
+
+	const fixtures = await getJSON(`/fixtures/upcoming?engine=${engine()}`);
+	for (const f of fixtures) {
+		const row = document.createElement("tr");
+		cell(row, f.date.slice(0, 16).replace("T", " "));
+
+		if (!f.prediction) {
+			cell(row, f.homeTeamId);
+			cell(row, f.awayTeamId);
+			const td = document.createElement("td");
+			td.colSpan = 4;
+			td.className = "error";
+			td.textContent = f.error;
+			row.appendChild(td);
+			body.appendChild(row);
+			continue;
+		}
+
+		const p = f.prediction;
+		cell(row, p.homeTeam);
+		cell(row, p.awayTeam);
+		cell(row, percent(p.outcomes.home));
+		cell(row, percent(p.outcomes.draw));
+		cell(row, percent(p.outcomes.away));
+		cell(row, `${p.homeExpectedGoals.toFixed(2)} - ${p.awayExpectedGoals.toFixed(2)}`);
+		body.appendChild(row);
+	}
+}
+
+async function loadCalibration() {
+	const svg = document.getElementById("calibration");
+	svg.replaceChildren();
+
+	const buckets = await getJSON(`/backtest/calibration?engine=${engine()}`);
+	const size = 320;
+	const margin = 30;
+	const scale = (v) => margin + v * (size - 2 * margin);
+	const largest = Math.max(...buckets.map((b) => b.count), 1);
+
+	svg.appendChild(svgElement("line", { class: "axis", x1: margin, y1: size - margin, x2: size - margin, y2: size - margin }));
+	svg.appendChild(svgElement("line", { class: "axis", x1: margin, y1: margin, x2: margin, y2: size - margin }));
+	svg.appendChild(svgElement("line", { class: "diagonal", x1: scale(0), y1: size - scale(0), x2: scale(1), y2: size - scale(1) }));
+	svg.appendChild(svgElement("text", { x: size / 2, y: size - 6, "text-anchor": "middle" }, "predicted"));
+	svg.appendChild(svgElement("text", { x: 10, y: size / 2, transform: `rotate(-90 10 ${size / 2})`, "text-anchor": "middle" }, "observed"));
+
+	for (const b of buckets) {
+		if (b.count === 0) {
+			continue;
+		}
+		const point = svgElement("circle", {
+			class: "point",
+			cx: scale(b.predicted),
+			cy: size - scale(b.observed),
+			r: 3 + 7 * Math.sqrt(b.count / largest),
+		});
+		point.appendChild(svgElement("title", {}, `${b.count} fixtures: predicted ${percent(b.predicted)}, observed ${percent(b.observed)}`));
+		svg.appendChild(point);
+	}
+}
+
+function report(error) {
+	console.error(error);
+}
+
+function refresh() {
+	loadRatings().catch(report);
+	loadHistory().catch(report);
+	loadUpcoming().catch(report);
+	loadCalibration().catch(report);
+}
+
+document.getElementById("engine").addEventListener("change", refresh);
+refresh();
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var db *sql.DB
+
+// dialect is the SQL dialect of the open database, sqlite3 or postgres
+var dialect = "sqlite3"
+
+// postgresDriver is lib/pq with the ? placeholders used throughout the
+// programs rewritten to the $1, $2, ... form PostgreSQL expects
+type postgresDriver struct {
+	pq.Driver
+}
+
+type postgresConn struct {
+	driver.Conn
+}
+
+func (d postgresDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return postgresConn{conn}, nil
+}
+
+func (c postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+// rebind numbers the ? placeholders of a query, leaving string literals alone
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		if r == '\'' {
+			inString = !inString
+		}
+		if r == '?' && !inString {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func init() {
+	sql.Register("postgres-rebind", postgresDriver{})
+}
+
+// initDB opens the configured database. A postgres:// URL selects
+// PostgreSQL, anything else is the path of a SQLite file.
+func initDB(database databaseConfig) error {
+	dsn := database.Database
+
+	var err error
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		dialect = "postgres"
+		db, err = sql.Open("postgres-rebind", dsn)
+	} else {
+		dialect = "sqlite3"
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		db, err = sql.Open("sqlite3", fmt.Sprintf("%s%s_timeout=%d&_busy_timeout=%d", dsn, separator, database.BusyTimeout, database.BusyTimeout))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+
+	// Test the connection
+	if err = db.Ping(); err != nil {
+		return fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	return nil
+}
+
+func getDB() *sql.DB {
+	return db
+}
+
+func closeDB() {
+	if db != nil {
+		db.Close()
+	}
+}
+
+// schema is every table and column the programs put into a query string.
+// Identifiers cannot be bound as parameters like values, so they are checked
+// against this list before being interpolated.
+var schema = map[string][]string{
+	"fixtures":            {"fixtureId", "homeTeam", "awayTeam", "homeTeamScore", "awayTeamScore", "round", "date", "statsCoverage", "league", "season"},
+	"score":               {"fixtureId", "team", "score"},
+	"totalShots":          {"fixtureId", "team", "totalShots"},
+	"ballPossession":      {"fixtureId", "team", "ballPossession"},
+	"expectedGoals":       {"fixtureId", "team", "expectedGoals"},
+	"teams":               {"id", "name", "code", "country", "logo", "venue", "city"},
+	"upcomingFixtures":    {"fixtureId", "homeTeam", "awayTeam", "round", "date"},
+	"odds":                {"fixtureId", "bookmaker", "homeOdds", "drawOdds", "awayOdds", "updated"},
+	"backfillJobs":        {"fixtureId", "season", "status", "attempts", "error", "updated"},
+	"lineups":             {"fixtureId", "team", "formation", "coach"},
+	"lineupPlayers":       {"fixtureId", "team", "player", "number", "position", "grid", "starting"},
+	"players":             {"id", "name"},
+	"playerStatistics":    {"fixtureId", "team", "player", "minutes", "rating", "position", "captain", "substitute", "goals", "assists", "shots", "goalsConceded", "saves"},
+	"lineupJobs":          {"fixtureId", "status", "attempts", "error", "updated"},
+	"injuries":            {"fixtureId", "team", "player", "type", "reason"},
+	"teamAliases":         {"alias", "team"},
+	"elo":                 {"team", "goalElo", "winnerElo", "ballPossessionElo", "totalShotsElo", "expectedGoalsElo", "attackElo", "defenceElo", "expectedGoalsAttackElo", "expectedGoalsDefenceElo"},
+	"eloRaw":              {"team", "goalElo", "winnerElo", "ballPossessionElo", "totalShotsElo", "expectedGoalsElo", "attackElo", "defenceElo", "expectedGoalsAttackElo", "expectedGoalsDefenceElo"},
+	"glicko":              {"team", "rating", "deviation", "volatility"},
+	"piRatings":           {"team", "homeRating", "awayRating"},
+	"ratingHistory":       {"engine", "fixtureId", "date", "team", "rating"},
+	"backtestPredictions": {"engine", "fixtureId", "date", "homeWin", "outcome", "probabilityHome", "probabilityDraw", "probabilityAway"},
+	"appliedFixtures":     {"engine", "fixtureId"},
+	"eloRanges":           {"statistic", "minimum", "maximum"},
+}
+
+// columnTypes are the types addColumnIfMissing may add. Floats are DOUBLE
+// PRECISION because REAL is only single precision in PostgreSQL.
+var columnTypes = map[string]bool{"INTEGER": true, "DOUBLE PRECISION": true, "TEXT": true}
+
+// checkIdentifiers returns an error unless table and all columns are in schema
+func checkIdentifiers(table string, columns ...string) error {
+	known, ok := schema[table]
+	if !ok {
+		return fmt.Errorf("unknown table %q", table)
+	}
+	for _, column := range columns {
+		if !slices.Contains(known, column) {
+			return fmt.Errorf("unknown column %q in table %s", column, table)
+		}
+	}
+	return nil
+}
+
+func addColumnIfMissing(table string, column string, columnType string) error {
+	if err := checkIdentifiers(table, column); err != nil {
+		return err
+	}
+	if !columnTypes[columnType] {
+		return fmt.Errorf("unknown column type %q", columnType)
+	}
+
+	// PostgreSQL can skip an existing column by itself
+	if dialect == "postgres" {
+		_, err := getDB().Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, columnType))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %v", column, table, err)
+		}
+		return nil
+	}
+
+	var exists int
+	err := getDB().QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %v", table, err)
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	_, err = getDB().Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s to %s: %v", column, table, err)
+	}
+	return nil
+}
+
+// tables holds the DDL of every table. Each program creates all of them, so
+// any of them can be the first to run against a fresh database.
+var tables = []struct {
+	name  string
+	query string
+}{
+	{"fixtures", "CREATE TABLE IF NOT EXISTS fixtures (fixtureId INTEGER PRIMARY KEY, homeTeam INTEGER, awayTeam INTEGER, homeTeamScore INTEGER, awayTeamScore INTEGER, round TEXT, date TEXT)"},
+	{"score", "CREATE TABLE IF NOT EXISTS score (fixtureId INTEGER, team INTEGER, score INTEGER)"},
+	{"totalShots", "CREATE TABLE IF NOT EXISTS totalShots (fixtureId INTEGER, team INTEGER, totalShots DOUBLE PRECISION)"},
+	{"ballPossession", "CREATE TABLE IF NOT EXISTS ballPossession (fixtureId INTEGER, team INTEGER, ballPossession DOUBLE PRECISION)"},
+	{"expectedGoals", "CREATE TABLE IF NOT EXISTS expectedGoals (fixtureId INTEGER, team INTEGER, expectedGoals DOUBLE PRECISION)"},
+	{"teams", "CREATE TABLE IF NOT EXISTS teams (id INTEGER PRIMARY KEY, name TEXT, code TEXT, country TEXT, logo TEXT, venue TEXT, city TEXT)"},
+	{"upcomingFixtures", "CREATE TABLE IF NOT EXISTS upcomingFixtures (fixtureId INTEGER PRIMARY KEY, homeTeam INTEGER, awayTeam INTEGER, round TEXT, date TEXT)"},
+	{"odds", "CREATE TABLE IF NOT EXISTS odds (fixtureId INTEGER, bookmaker TEXT, homeOdds DOUBLE PRECISION, drawOdds DOUBLE PRECISION, awayOdds DOUBLE PRECISION, updated TEXT, PRIMARY KEY (fixtureId, bookmaker))"},
+	// one row per played fixture, so an interrupted backfill knows which
+	// fixtures still have to be fetched
+	{"backfillJobs", "CREATE TABLE IF NOT EXISTS backfillJobs (fixtureId INTEGER PRIMARY KEY, season TEXT, status TEXT, attempts INTEGER DEFAULT 0, error TEXT, updated TEXT)"},
+	// the formation and coach of each team, and who started and who was on
+	// the bench
+	{"lineups", "CREATE TABLE IF NOT EXISTS lineups (fixtureId INTEGER, team INTEGER, formation TEXT, coach INTEGER, PRIMARY KEY (fixtureId, team))"},
+	{"lineupPlayers", "CREATE TABLE IF NOT EXISTS lineupPlayers (fixtureId INTEGER, team INTEGER, player INTEGER, number INTEGER, position TEXT, grid TEXT, starting INTEGER, PRIMARY KEY (fixtureId, player))"},
+	{"players", "CREATE TABLE IF NOT EXISTS players (id INTEGER PRIMARY KEY, name TEXT)"},
+	// what every player who took part did, rating is API-Football's match rating
+	{"playerStatistics", "CREATE TABLE IF NOT EXISTS playerStatistics (fixtureId INTEGER, team INTEGER, player INTEGER, minutes INTEGER, rating DOUBLE PRECISION, position TEXT, " +
+		"captain INTEGER, substitute INTEGER, goals INTEGER, assists INTEGER, shots INTEGER, goalsConceded INTEGER, saves INTEGER, PRIMARY KEY (fixtureId, player))"},
+	// lineups are fetched separately from the statistics, so fixtures
+	// backfilled before lineups were stored get them too
+	{"lineupJobs", "CREATE TABLE IF NOT EXISTS lineupJobs (fixtureId INTEGER PRIMARY KEY, status TEXT, attempts INTEGER DEFAULT 0, error TEXT, updated TEXT)"},
+	// players the API expects to miss an upcoming fixture ("Missing Fixture")
+	// or who are doubtful ("Questionable"), with the reason such as an injury
+	// or a suspension
+	{"injuries", "CREATE TABLE IF NOT EXISTS injuries (fixtureId INTEGER, team INTEGER, player INTEGER, type TEXT, reason TEXT, PRIMARY KEY (fixtureId, player))"},
+	// the names other sources, such as football-data.co.uk, use for a team
+	{"teamAliases", "CREATE TABLE IF NOT EXISTS teamAliases (alias TEXT PRIMARY KEY, team INTEGER)"},
+	{"elo", "CREATE TABLE IF NOT EXISTS elo (team INTEGER PRIMARY KEY, goalElo DOUBLE PRECISION, winnerElo DOUBLE PRECISION, ballPossessionElo DOUBLE PRECISION, totalShotsElo DOUBLE PRECISION, expectedGoalsElo DOUBLE PRECISION, attackElo DOUBLE PRECISION, defenceElo DOUBLE PRECISION, expectedGoalsAttackElo DOUBLE PRECISION, expectedGoalsDefenceElo DOUBLE PRECISION)"},
+	// the elo table holds normalized ratings, so the raw ratings an
+	// incremental run continues from are kept here
+	{"eloRaw", "CREATE TABLE IF NOT EXISTS eloRaw (team INTEGER PRIMARY KEY, goalElo DOUBLE PRECISION, winnerElo DOUBLE PRECISION, ballPossessionElo DOUBLE PRECISION, totalShotsElo DOUBLE PRECISION, expectedGoalsElo DOUBLE PRECISION, attackElo DOUBLE PRECISION, defenceElo DOUBLE PRECISION, expectedGoalsAttackElo DOUBLE PRECISION, expectedGoalsDefenceElo DOUBLE PRECISION)"},
+	{"glicko", "CREATE TABLE IF NOT EXISTS glicko (team INTEGER PRIMARY KEY, rating DOUBLE PRECISION, deviation DOUBLE PRECISION, volatility DOUBLE PRECISION)"},
+	{"piRatings", "CREATE TABLE IF NOT EXISTS piRatings (team INTEGER PRIMARY KEY, homeRating DOUBLE PRECISION, awayRating DOUBLE PRECISION)"},
+	{"ratingHistory", "CREATE TABLE IF NOT EXISTS ratingHistory (engine TEXT, fixtureId INTEGER, date TEXT, team INTEGER, rating DOUBLE PRECISION)"},
+	{"backtestPredictions", "CREATE TABLE IF NOT EXISTS backtestPredictions (engine TEXT, fixtureId INTEGER, date TEXT, homeWin DOUBLE PRECISION, outcome DOUBLE PRECISION, probabilityHome DOUBLE PRECISION, probabilityDraw DOUBLE PRECISION, probabilityAway DOUBLE PRECISION)"},
+	// the fixtures each engine has already rated, so a run only applies new ones
+	{"appliedFixtures", "CREATE TABLE IF NOT EXISTS appliedFixtures (engine TEXT, fixtureId INTEGER, PRIMARY KEY (engine, fixtureId))"},
+	// the ranges the raw Elo ratings normalized the statistics with, so an
+	// incremental run can tell whether it would still match a rebuild
+	{"eloRanges", "CREATE TABLE IF NOT EXISTS eloRanges (statistic TEXT PRIMARY KEY, minimum DOUBLE PRECISION, maximum DOUBLE PRECISION)"},
+}
+
+// createTables creates every table and adds the columns introduced after a
+// table was first created, so older databases keep working
+func createTables() error {
+	for _, table := range tables {
+		if _, err := getDB().Exec(table.query); err != nil {
+			return fmt.Errorf("failed to create %s table: %v", table.name, err)
+		}
+	}
+
+	// round and date let the rating engines group fixtures into matchdays,
+	// statsCoverage is full, partial or none, depending on which statistics
+	// were available
+	for _, column := range []string{"round", "date", "statsCoverage", "season"} {
+		if err := addColumnIfMissing("fixtures", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	// league and season let the fixtures of several competitions be told apart
+	if err := addColumnIfMissing("fixtures", "league", "INTEGER"); err != nil {
+		return err
+	}
+
+	for _, column := range []string{"probabilityHome", "probabilityDraw", "probabilityAway"} {
+		if err := addColumnIfMissing("backtestPredictions", column, "DOUBLE PRECISION"); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"expectedGoalsElo", "attackElo", "defenceElo", "expectedGoalsAttackElo", "expectedGoalsDefenceElo"} {
+		if err := addColumnIfMissing("elo", column, "DOUBLE PRECISION"); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"expectedGoalsAttackElo", "expectedGoalsDefenceElo"} {
+		if err := addColumnIfMissing("eloRaw", column, "DOUBLE PRECISION"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func enterDataIntoDB(db execer, table string, columns []string, data []interface{}) error {
+	if err := checkIdentifiers(table, columns...); err != nil {
+		return err
+	}
+
+	// Create placeholders for the SQL query
+	placeholders := make([]string, len(columns))
+	for i := range placeholders {
+		placeholders[i] = "?"
+	}
+
+	// Construct the SQL query
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table,
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "))
+
+	// Execute the query
+	_, err := db.Exec(query, data...)
+	if err != nil {
+		return fmt.Errorf("failed to insert data: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// cfg holds the settings of this program, which has none besides the
+// database
+var cfg = defaultDatabaseConfig
+
+// exportColumn describes one column of a dataset in the manifest. Type is
+// integer, double, string or boolean; every column may be null.
+type exportColumn struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// dataset is a table as the analysts see it, joined to team names and dates.
+// The query selects the columns in order and ends in a WHERE clause that the
+// filters are appended to.
+type dataset struct {
+	name        string
+	description string
+	columns     []exportColumn
+	query       string
+}
+
+var datasets = []dataset{
+	{
+		name:        "fixtures",
+		description: "played fixtures with their final score, one row per fixture",
+		columns: []exportColumn{
+			{"fixtureId", "integer", "API-Football fixture id"},
+			{"date", "string", "kick-off time, RFC 3339"},
+			{"league", "integer", "API-Football league id"},
+			{"season", "string", "season the fixture belongs to"},
+			{"round", "string", "matchday, e.g. Regular Season - 12"},
+			{"homeTeamId", "integer", "id of the home team"},
+			{"homeTeam", "string", "name of the home team"},
+			{"awayTeamId", "integer", "id of the away team"},
+			{"awayTeam", "string", "name of the away team"},
+			{"homeGoals", "integer", "goals of the home team"},
+			{"awayGoals", "integer", "goals of the away team"},
+			{"statsCoverage", "string", "full, partial or none, which statistics the API had"},
+		},
+		query: `
+			SELECT f.fixtureId, f.date, f.league, f.season, f.round,
+				f.homeTeam, h.name, f.awayTeam, a.name, f.homeTeamScore, f.awayTeamScore, f.statsCoverage
+			FROM fixtures f
+			LEFT JOIN teams h ON h.id = f.homeTeam
+			LEFT JOIN teams a ON a.id = f.awayTeam
+			WHERE 1 = 1`,
+	},
+	{
+		name:        "teamStats",
+		description: "statistics of each team in each played fixture, two rows per fixture",
+		columns: []exportColumn{
+			{"fixtureId", "integer", "API-Football fixture id"},
+			{"date", "string", "kick-off time, RFC 3339"},
+			{"league", "integer", "API-Football league id"},
+			{"season", "string", "season the fixture belongs to"},
+			{"teamId", "integer", "id of the team"},
+			{"team", "string", "name of the team"},
+			{"opponentId", "integer", "id of the opponent"},
+			{"opponent", "string", "name of the opponent"},
+			{"home", "boolean", "whether the team played at home"},
+			{"goals", "integer", "goals scored"},
+			{"goalsAgainst", "integer", "goals conceded"},
+			{"totalShots", "double", "total shots, null if the API had none"},
+			{"ballPossession", "double", "ball possession in percent, null if the API had none"},
+			{"expectedGoals", "double", "expected goals, null without xG coverage"},
+		},
+		query: `
+			SELECT f.fixtureId, f.date, f.league, f.season, s.team, t.name,
+				CASE WHEN s.team = f.homeTeam THEN f.awayTeam ELSE f.homeTeam END, o.name,
+				s.team = f.homeTeam, s.score,
+				CASE WHEN s.team = f.homeTeam THEN f.awayTeamScore ELSE f.homeTeamScore END,
+				ts.totalShots, bp.ballPossession, xg.expectedGoals
+			FROM score s
+			JOIN fixtures f ON f.fixtureId = s.fixtureId
+			LEFT JOIN teams t ON t.id = s.team
+			LEFT JOIN teams o ON o.id = CASE WHEN s.team = f.homeTeam THEN f.awayTeam ELSE f.homeTeam END
+			LEFT JOIN totalShots ts ON ts.fixtureId = s.fixtureId AND ts.team = s.team
+			LEFT JOIN ballPossession bp ON bp.fixtureId = s.fixtureId AND bp.team = s.team
+			LEFT JOIN expectedGoals xg ON xg.fixtureId = s.fixtureId AND xg.team = s.team
+			WHERE 1 = 1`,
+	},
+	{
+		name:        "ratingHistory",
+		description: "rating of a team after each fixture as recorded by createEloRanking, Elo on the raw scale",
+		columns: []exportColumn{
+			{"engine", "string", "rating engine: elo, glicko2 or pi"},
+			{"fixtureId", "integer", "API-Football fixture id"},
+			{"date", "string", "kick-off time, RFC 3339"},
+			{"league", "integer", "API-Football league id"},
+			{"season", "string", "season the fixture belongs to"},
+			{"teamId", "integer", "id of the team"},
+			{"team", "string", "name of the team"},
+			{"rating", "double", "rating after the fixture"},
+		},
+		query: `
+			SELECT r.engine, r.fixtureId, COALESCE(f.date, r.date), f.league, f.season, r.team, t.name, r.rating
+			FROM ratingHistory r
+			LEFT JOIN fixtures f ON f.fixtureId = r.fixtureId
+			LEFT JOIN teams t ON t.id = r.team
+			WHERE 1 = 1`,
+	},
+}
+
+// exportFilters narrow every dataset down by the fixture's league, season and
+// kick-off date. Zero values don't filter.
+type exportFilters struct {
+	League int    `json:"league,omitempty"`
+	Season string `json:"season,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// clause returns the conditions for the fixture f, to is inclusive
+func (e exportFilters) clause() (string, []interface{}, error) {
+	var until string
+	if e.To != "" {
+		to, err := time.Parse("2006-01-02", e.To)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid -to date %q, expected YYYY-MM-DD", e.To)
+		}
+		until = to.AddDate(0, 0, 1).Format("2006-01-02")
+	}
+	if e.From != "" {
+		if _, err := time.Parse("2006-01-02", e.From); err != nil {
+			return "", nil, fmt.Errorf("invalid -from date %q, expected YYYY-MM-DD", e.From)
+		}
+	}
+
+	clause := `
+		AND (? = 0 OR f.league = ?)
+		AND (? = '' OR f.season = ?)
+		AND (? = '' OR f.date >= ?)
+		AND (? = '' OR f.date < ?)
+		ORDER BY f.date, f.fixtureId`
+	args := []interface{}{e.League, e.League, e.Season, e.Season, e.From, e.From, until, until}
+	return clause, args, nil
+}
+
+// queryDataset returns the rows of a dataset with nil for NULL and int64,
+// float64, string or bool otherwise
+func queryDataset(d dataset, filters exportFilters) ([][]interface{}, error) {
+	clause, args, err := filters.clause()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(d.query+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %v", d.name, err)
+	}
+	defer rows.Close()
+
+	var result [][]interface{}
+	for rows.Next() {
+		targets := make([]interface{}, len(d.columns))
+		for i, column := range d.columns {
+			switch column.Type {
+			case "integer":
+				targets[i] = &sql.NullInt64{}
+			case "double":
+				targets[i] = &sql.NullFloat64{}
+			case "boolean":
+				targets[i] = &sql.NullBool{}
+			default:
+				targets[i] = &sql.NullString{}
+			}
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %v", d.name, err)
+		}
+
+		row := make([]interface{}, len(d.columns))
+		for i, target := range targets {
+			switch v := target.(type) {
+			case *sql.NullInt64:
+				if v.Valid {
+					row[i] = v.Int64
+				}
+			case *sql.NullFloat64:
+				if v.Valid {
+					row[i] = v.Float64
+				}
+			case *sql.NullBool:
+				if v.Valid {
+					row[i] = v.Bool
+				}
+			case *sql.NullString:
+				if v.Valid {
+					row[i] = v.String
+				}
+			}
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", d.name, err)
+	}
+
+	return result, nil
+}
+
+// writeCSV writes the rows with a header row, NULL becomes an empty field
+func writeCSV(path string, columns []exportColumn, rows [][]interface{}) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, value := range row {
+			switch v := value.(type) {
+			case nil:
+				record[i] = ""
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeParquet writes the rows with every column optional, so NULL survives
+// as a missing value
+func writeParquet(path string, name string, columns []exportColumn, rows [][]interface{}) error {
+	group := parquet.Group{}
+	for _, column := range columns {
+		switch column.Type {
+		case "integer":
+			group[column.Name] = parquet.Optional(parquet.Int(64))
+		case "double":
+			group[column.Name] = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+		case "boolean":
+			group[column.Name] = parquet.Optional(parquet.Leaf(parquet.BooleanType))
+		default:
+			group[column.Name] = parquet.Optional(parquet.String())
+		}
+	}
+	schema := parquet.NewSchema(name, group)
+
+	// a group orders its columns by name, so look up where each one ended up
+	indexes := make([]int, len(columns))
+	for i, column := range columns {
+		leaf, ok := schema.Lookup(column.Name)
+		if !ok {
+			return fmt.Errorf("column %s missing from parquet schema", column.Name)
+		}
+		indexes[i] = leaf.ColumnIndex
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := parquet.NewWriter(file, schema)
+	for _, row := range rows {
+		record := make(parquet.Row, len(columns))
+		for i, value := range row {
+			var v parquet.Value
+			switch value := value.(type) {
+			case nil:
+				record[indexes[i]] = parquet.NullValue().Level(0, 0, indexes[i])
+				continue
+			case int64:
+				v = parquet.Int64Value(value)
+			case float64:
+				v = parquet.DoubleValue(value)
+			case bool:
+				v = parquet.BooleanValue(value)
+			case string:
+				v = parquet.ByteArrayValue([]byte(value))
+			}
+			record[indexes[i]] = v.Level(0, 1, indexes[i])
+		}
+		if _, err := writer.WriteRows([]parquet.Row{record}); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// manifestDataset is the manifest entry of one exported file
+type manifestDataset struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	File        string         `json:"file"`
+	Rows        int            `json:"rows"`
+	Columns     []exportColumn `json:"columns"`
+}
+
+// manifest describes an export so a notebook can load the files without
+// guessing types
+type manifest struct {
+	GeneratedAt time.Time         `json:"generatedAt"`
+	Format      string            `json:"format"`
+	Filters     exportFilters     `json:"filters"`
+	Datasets    []manifestDataset `json:"datasets"`
+}
+
+// exportDatasets writes the named datasets, or all of them, to dir together
+// with manifest.json
+func exportDatasets(dir string, format string, names []string, filters exportFilters) error {
+	m := manifest{
+		GeneratedAt: time.Now().UTC(),
+		Format:      format,
+		Filters:     filters,
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	for _, d := range datasets {
+		if len(names) > 0 && !slices.Contains(names, d.name) {
+			continue
+		}
+
+		rows, err := queryDataset(d, filters)
+		if err != nil {
+			return err
+		}
+
+		file := d.name + "." + format
+		path := filepath.Join(dir, file)
+		if format == "parquet" {
+			err = writeParquet(path, d.name, d.columns, rows)
+		} else {
+			err = writeCSV(path, d.columns, rows)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		fmt.Printf("Wrote %d rows to %s\n", len(rows), path)
+
+		m.Datasets = append(m.Datasets, manifestDataset{
+			Name:        d.name,
+			Description: d.description,
+			File:        file,
+			Rows:        len(rows),
+			Columns:     d.columns,
+		})
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "manifest.json")
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	return nil
+}
+
+func main() {
+	format := flag.String("format", "csv", "file format: csv or parquet")
+	dir := flag.String("out", "export", "directory the files and manifest.json are written to")
+	only := flag.String("datasets", "", "comma separated datasets to export (fixtures, teamStats, ratingHistory), all if empty")
+	var filters exportFilters
+	flag.IntVar(&filters.League, "league", 0, "only fixtures of this league id")
+	flag.StringVar(&filters.Season, "season", "", "only fixtures of this season")
+	flag.StringVar(&filters.From, "from", "", "only fixtures on or after this date (YYYY-MM-DD)")
+	flag.StringVar(&filters.To, "to", "", "only fixtures on or before this date (YYYY-MM-DD)")
+	flag.Parse()
+
+	if *format != "csv" && *format != "parquet" {
+		log.Fatalf("Unknown format: %s", *format)
+	}
+
+	var names []string
+	if *only != "" {
+		for _, name := range strings.Split(*only, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.ContainsFunc(datasets, func(d dataset) bool { return d.name == name }) {
+				log.Fatalf("Unknown dataset: %s", name)
+			}
+			names = append(names, name)
+		}
+	}
+
+	if err := readConfig(&cfg, &cfg); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := initDB(cfg); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer closeDB()
+
+	if err := createTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+
+	if err := exportDatasets(*dir, *format, names, filters); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+}
@@ -1,52 +1,262 @@
 package main
 
 import (
+	"cmp"
 	"database/sql"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-
-	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
-var db *sql.DB
+// config holds the settings of this program. The defaults below are
+// overridden by the config file, and the config file by the environment.
+type config struct {
+	databaseConfig `yaml:",inline"`
+	// weights of the Elo components in the combined rating, without and with
+	// expected goals; createEloRanking has to use the same weights
+	Weights              map[string]float64 `yaml:"weights"`
+	ExpectedGoalsWeights map[string]float64 `yaml:"expectedGoalsWeights"`
+	// share of the xG-for and xG-against Elos in the attack and defence
+	// strengths of the Poisson model; createEloRanking has to use the same
+	ExpectedGoalsShare float64 `yaml:"expectedGoalsShare"`
+	// Elo points a team gains for each point its starting XI's average player
+	// rating is above that of its regulars, and how many recent fixtures the
+	// player ratings are taken from
+	LineupWeight   float64 `yaml:"lineupWeight"`
+	LineupFixtures int     `yaml:"lineupFixtures"`
+}
 
-func initDB() error {
-	var err error
-	db, err = sql.Open("sqlite3", "./FootballTracker.db?_timeout=10000&_busy_timeout=10000")
-	if err != nil {
-		return fmt.Errorf("failed to open database: %v", err)
-	}
+var cfg = config{
+	databaseConfig: defaultDatabaseConfig,
+	Weights: map[string]float64{
+		"goal": 0.4, "winner": 0.3, "totalShots": 0.15, "ballPossession": 0.15,
+	},
+	ExpectedGoalsWeights: map[string]float64{
+		"goal": 0.3, "winner": 0.25, "expectedGoals": 0.25, "totalShots": 0.1, "ballPossession": 0.1,
+	},
+	ExpectedGoalsShare: 0.5,
+	LineupWeight:       250,
+	LineupFixtures:     10,
+}
 
-	// Test the connection
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %v", err)
+// loadConfig reads config.yaml, or the file named by CONFIG_FILE, and then
+// the environment. Neither the config file nor .env have to exist.
+func loadConfig() error {
+	// the names of a section are fixed by its defaults
+	weightNames := settingNames(cfg.Weights)
+	expectedGoalsWeightNames := settingNames(cfg.ExpectedGoalsWeights)
+
+	if err := readConfig(&cfg, &cfg.databaseConfig); err != nil {
+		return err
 	}
 
-	return nil
-}
+	var err error
+	if value := os.Getenv("EXPECTED_GOALS_SHARE"); value != "" {
+		cfg.ExpectedGoalsShare, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid EXPECTED_GOALS_SHARE %q: %v", value, err)
+		}
+	}
+	if cfg.ExpectedGoalsShare < 0 || cfg.ExpectedGoalsShare > 1 {
+		return fmt.Errorf("expectedGoalsShare must be between 0 and 1")
+	}
+	if value := os.Getenv("LINEUP_WEIGHT"); value != "" {
+		cfg.LineupWeight, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid LINEUP_WEIGHT %q: %v", value, err)
+		}
+	}
+	if value := os.Getenv("LINEUP_FIXTURES"); value != "" {
+		cfg.LineupFixtures, err = strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid LINEUP_FIXTURES %q: %v", value, err)
+		}
+	}
+	if cfg.LineupFixtures < 1 {
+		return fmt.Errorf("lineupFixtures must be at least 1")
+	}
+	if err := parseSettings("ELO_WEIGHTS", cfg.Weights); err != nil {
+		return err
+	}
+	if err := parseSettings("ELO_EXPECTED_GOALS_WEIGHTS", cfg.ExpectedGoalsWeights); err != nil {
+		return err
+	}
 
-func closeDB() {
-	db.Close()
+	if err := checkSettings("weights", cfg.Weights, weightNames); err != nil {
+		return err
+	}
+	return checkSettings("expectedGoalsWeights", cfg.ExpectedGoalsWeights, expectedGoalsWeightNames)
 }
 
 func getEloForTeam(teamID int) (float64, error) {
-	query := "SELECT * from elo where team = ?"
+	query := "SELECT team, goalElo, winnerElo, totalShotsElo, ballPossessionElo, expectedGoalsElo from elo where team = ?"
 	row := db.QueryRow(query, teamID)
 	var team float64
 	var goalElo float64
 	var winnerElo float64
 	var totalShotsElo float64
 	var ballPossessionElo float64
+	var expectedGoalsElo sql.NullFloat64
 
-	err := row.Scan(&team, &goalElo, &winnerElo, &totalShotsElo, &ballPossessionElo)
+	err := row.Scan(&team, &goalElo, &winnerElo, &totalShotsElo, &ballPossessionElo, &expectedGoalsElo)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get elo for team: %v", err)
 	}
 
-	averagedElo := goalElo*0.4 + winnerElo*0.3 + totalShotsElo*0.15 + ballPossessionElo*0.15
-	return averagedElo, nil
+	return combineElo(goalElo, winnerElo, totalShotsElo, ballPossessionElo, expectedGoalsElo), nil
+}
+
+func combineElo(goalElo float64, winnerElo float64, totalShotsElo float64, ballPossessionElo float64, expectedGoalsElo sql.NullFloat64) float64 {
+	// Teams without xG coverage fall back to the original weighting
+	if !expectedGoalsElo.Valid {
+		w := cfg.Weights
+		return goalElo*w["goal"] + winnerElo*w["winner"] + totalShotsElo*w["totalShots"] + ballPossessionElo*w["ballPossession"]
+	}
+
+	w := cfg.ExpectedGoalsWeights
+	return goalElo*w["goal"] + winnerElo*w["winner"] + expectedGoalsElo.Float64*w["expectedGoals"] + totalShotsElo*w["totalShots"] + ballPossessionElo*w["ballPossession"]
+}
+
+// getAttackDefenceForTeam returns the strengths the Poisson model uses, with
+// the xG-for and xG-against Elos blended in
+func getAttackDefenceForTeam(teamID int) (float64, float64, error) {
+	query := "SELECT attackElo, defenceElo, expectedGoalsAttackElo, expectedGoalsDefenceElo from elo where team = ?"
+	row := db.QueryRow(query, teamID)
+	var attackElo float64
+	var defenceElo float64
+	var expectedGoalsAttackElo sql.NullFloat64
+	var expectedGoalsDefenceElo sql.NullFloat64
+
+	err := row.Scan(&attackElo, &defenceElo, &expectedGoalsAttackElo, &expectedGoalsDefenceElo)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get attack and defence elo for team: %v", err)
+	}
+
+	return blendStrength(attackElo, expectedGoalsAttackElo), blendStrength(defenceElo, expectedGoalsDefenceElo), nil
+}
+
+// blendStrength mixes a goal-based attack or defence Elo with its xG-for or
+// xG-against counterpart, if the team has xG coverage
+func blendStrength(elo float64, expectedGoalsElo sql.NullFloat64) float64 {
+	if !expectedGoalsElo.Valid {
+		return elo
+	}
+	return (1-cfg.ExpectedGoalsShare)*elo + cfg.ExpectedGoalsShare*expectedGoalsElo.Float64
+}
+
+// getAverageGoals returns the average number of goals a single team scores per match
+func getAverageGoals() (float64, error) {
+	query := "SELECT AVG(homeTeamScore + awayTeamScore) / 2.0 FROM fixtures"
+	var averageGoals sql.NullFloat64
+
+	err := db.QueryRow(query).Scan(&averageGoals)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get average goals: %v", err)
+	}
+	if !averageGoals.Valid {
+		return 0, fmt.Errorf("no fixtures to average goals over")
+	}
+
+	return averageGoals.Float64, nil
+}
+
+// calcExpectedGoals scales the league average by how the attack fares against
+// the defence: evenly matched ratings give the average, a stronger attack more.
+func calcExpectedGoals(attackElo float64, defenceElo float64, averageGoals float64) float64 {
+	return averageGoals * 2 / (1 + math.Pow(10, (defenceElo-attackElo)/400))
+}
+
+// outcomeProbabilities are the 1X2 probabilities of a fixture
+type outcomeProbabilities struct {
+	Home float64 `json:"home"`
+	Draw float64 `json:"draw"`
+	Away float64 `json:"away"`
+}
+
+func poissonProbability(goals int, expectedGoals float64) float64 {
+	probability := math.Exp(-expectedGoals)
+	for i := 1; i <= goals; i++ {
+		probability *= expectedGoals / float64(i)
+	}
+	return probability
+}
+
+// calcOutcomeProbabilities treats both teams' goals as independent Poisson
+// variables, which is what gives the model a draw probability
+func calcOutcomeProbabilities(homeExpectedGoals float64, awayExpectedGoals float64) outcomeProbabilities {
+	var outcomes outcomeProbabilities
+	for homeGoals := 0; homeGoals <= 10; homeGoals++ {
+		for awayGoals := 0; awayGoals <= 10; awayGoals++ {
+			probability := poissonProbability(homeGoals, homeExpectedGoals) * poissonProbability(awayGoals, awayExpectedGoals)
+			if homeGoals > awayGoals {
+				outcomes.Home += probability
+			} else if homeGoals < awayGoals {
+				outcomes.Away += probability
+			} else {
+				outcomes.Draw += probability
+			}
+		}
+	}
+
+	// scale away the tail beyond ten goals
+	total := outcomes.Home + outcomes.Draw + outcomes.Away
+	outcomes.Home /= total
+	outcomes.Draw /= total
+	outcomes.Away /= total
+	return outcomes
+}
+
+// goalDifferenceDeviation is the spread of the actual goal difference around
+// the expected one, as in createEloRanking. Every engine shares it: an engine
+// that rates a fixture more confidently already gives a larger expected goal
+// difference, while how far results scatter around it is a property of the
+// matches rather than of the ratings. One value also keeps the 1X2 split of
+// the predictions the same as the one backtestPredictions stores for every
+// engine.
+const goalDifferenceDeviation = 1.6
+
+func normalCDF(x float64) float64 {
+	return 0.5 * (1 + math.Erf(x/math.Sqrt2))
+}
+
+// impliedGoalDifference is the expected goal difference that gives a home win
+// probability, draws counting half, under a normal distribution
+func impliedGoalDifference(homeWinProbability float64) float64 {
+	p := math.Min(math.Max(homeWinProbability, 1e-6), 1-1e-6)
+	return goalDifferenceDeviation * math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// calcEngineOutcomes splits a rating engine's home win probability into 1X2
+// the way createEloRanking does for backtestPredictions, so value bets are
+// priced with the same model that the bankroll simulation backtests. Anything
+// within half a goal of the implied goal difference is a draw.
+func calcEngineOutcomes(homeWinProbability float64) outcomeProbabilities {
+	goalDifference := impliedGoalDifference(homeWinProbability)
+
+	home := 1 - normalCDF((0.5-goalDifference)/goalDifferenceDeviation)
+	away := normalCDF((-0.5 - goalDifference) / goalDifferenceDeviation)
+	return outcomeProbabilities{Home: home, Draw: 1 - home - away, Away: away}
+}
+
+// engineExpectedGoals splits a goal difference around the league average, for
+// the engines that do not rate attack and defence
+func engineExpectedGoals(goalDifference float64, averageGoals float64) (float64, float64) {
+	return math.Max(averageGoals+goalDifference/2, 0), math.Max(averageGoals-goalDifference/2, 0)
 }
 
 func calcChancesFromElo(team1Elo float64, team2Elo float64) (float64, float64) {
@@ -55,66 +265,2309 @@ func calcChancesFromElo(team1Elo float64, team2Elo float64) (float64, float64) {
 	return team1Chances, team2Chances
 }
 
-func calculateChances(team1ID int, team2ID int) (float64, float64) {
+// calculateChances adds adjustment, the difference of both teams' lineup
+// adjustments, to team1's Elo before comparing the ratings
+func calculateChances(team1ID int, team2ID int, adjustment float64) (float64, float64, error) {
 	team1Elo, err := getEloForTeam(team1ID)
 	if err != nil {
-		log.Fatalf("Failed to get elo for team: %v", err)
+		return 0, 0, err
 	}
+	team1Elo += adjustment
 	team2Elo, err := getEloForTeam(team2ID)
 	if err != nil {
-		log.Fatalf("Failed to get elo for team: %v", err)
+		return 0, 0, err
+	}
+
+	team1Chances, team2Chances := calcChancesFromElo(team1Elo, team2Elo)
+	return team1Chances, team2Chances, nil
+}
+
+const glickoScale = 173.7178
+
+func getGlickoForTeam(teamID int) (float64, float64, error) {
+	query := "SELECT rating, deviation from glicko where team = ?"
+	row := db.QueryRow(query, teamID)
+	var rating float64
+	var deviation float64
+
+	err := row.Scan(&rating, &deviation)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get glicko rating for team: %v", err)
+	}
+
+	return rating, deviation, nil
+}
+
+// calculateGlickoChances returns team1's win probability together with a 95%
+// interval, taken from shifting the rating gap by 1.96 combined deviations.
+// The bounds are damped by the same g as the estimate, so they contain it.
+// Like in calculateChances, adjustment is added to team1's rating.
+func calculateGlickoChances(team1ID int, team2ID int, adjustment float64) (float64, float64, float64, error) {
+	team1Rating, team1Deviation, err := getGlickoForTeam(team1ID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	team2Rating, team2Deviation, err := getGlickoForTeam(team2ID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	ratingGap := (team1Rating + adjustment - team2Rating) / glickoScale
+	combinedPhi := math.Sqrt(team1Deviation*team1Deviation+team2Deviation*team2Deviation) / glickoScale
+	g := 1 / math.Sqrt(1+3*combinedPhi*combinedPhi/(math.Pi*math.Pi))
+
+	team1Chances := 1 / (1 + math.Exp(-g*ratingGap))
+	low := 1 / (1 + math.Exp(-g*(ratingGap-1.96*combinedPhi)))
+	high := 1 / (1 + math.Exp(-g*(ratingGap+1.96*combinedPhi)))
+
+	return team1Chances, low, high, nil
+}
+
+const (
+	piBase      = 10
+	piGoalScale = 3
+)
+
+func getPiForTeam(teamID int) (float64, float64, error) {
+	query := "SELECT homeRating, awayRating from piRatings where team = ?"
+	row := db.QueryRow(query, teamID)
+	var homeRating float64
+	var awayRating float64
+
+	err := row.Scan(&homeRating, &awayRating)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get pi rating for team: %v", err)
+	}
+
+	return homeRating, awayRating, nil
+}
+
+func piExpectedGoalDifference(rating float64) float64 {
+	goalDifference := math.Pow(piBase, math.Abs(rating)/piGoalScale) - 1
+	if rating < 0 {
+		return -goalDifference
+	}
+	return goalDifference
+}
+
+// calculatePiChances treats team1 as the home side and returns its win
+// probability along with the predicted goal difference. Pi-ratings are in
+// goals rather than Elo points, so adjustment, team1's lineup advantage in
+// Elo points, adds the goal difference that gives the same win probability.
+func calculatePiChances(team1ID int, team2ID int, adjustment float64) (float64, float64, error) {
+	team1HomeRating, _, err := getPiForTeam(team1ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, team2AwayRating, err := getPiForTeam(team2ID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	goalDifference := piExpectedGoalDifference(team1HomeRating) - piExpectedGoalDifference(team2AwayRating)
+	if adjustment != 0 {
+		lineupChances, _ := calcChancesFromElo(adjustment, 0)
+		goalDifference += impliedGoalDifference(lineupChances)
+	}
+	return piChances(goalDifference), goalDifference, nil
+}
+
+// piChances is the home win probability for a predicted goal difference
+func piChances(goalDifference float64) float64 {
+	return normalCDF(goalDifference / goalDifferenceDeviation)
+}
+
+// lineupPriorMinutes pulls the value of players with little playing time
+// towards the team average, so one good cameo does not make a key player
+const lineupPriorMinutes = 270
+
+// playerValue is what a player is worth to a team: their minutes-weighted
+// average match rating over the team's recent fixtures
+type playerValue struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Position string  `json:"position"`
+	Minutes  int     `json:"minutes"`
+	Value    float64 `json:"value"`
+}
+
+// teamPlayers holds the player values of a team over its recent fixtures
+type teamPlayers struct {
+	values map[int]playerValue
+	// the eleven most frequent starters, whose strength the ratings reflect
+	regulars []int
+	// the starters of the most recent fixture
+	latest []int
+	// value of a player without statistics, the average of the non-regulars
+	replacement float64
+}
+
+// lineupAdjustment is the change to a team's ratings for a starting XI. Elo
+// is added to the team's Elo and Glicko-2 ratings. Attack and Defence split
+// it by where the changed players play, as described at adjust, and are
+// added to the attack and defence ratings.
+type lineupAdjustment struct {
+	// announced for the fixture or expected from the last lineup
+	Source  string  `json:"source"`
+	Elo     float64 `json:"elo"`
+	Attack  float64 `json:"attack"`
+	Defence float64 `json:"defence"`
+	// regulars who are not in the XI and the players starting instead
+	Missing      []playerValue `json:"missing"`
+	Replacements []playerValue `json:"replacements"`
+	// players ruled out of the fixture by an injury or a suspension, left
+	// out of the expected XI
+	Unavailable []playerValue `json:"unavailable"`
+}
+
+// recentLineupFixtures selects the most recent fixtures a team has a stored
+// lineup for
+const recentLineupFixtures = `
+	SELECT l.fixtureId FROM lineups l JOIN fixtures f ON f.fixtureId = l.fixtureId
+	WHERE l.team = ? ORDER BY f.date DESC, f.fixtureId DESC LIMIT ?
+`
+
+// getTeamPlayers values the players of a team from the lineups and player
+// statistics stored by getDataFromAPI. It returns nil if there are none.
+func getTeamPlayers(teamID int) (*teamPlayers, error) {
+	rows, err := db.Query(recentLineupFixtures, teamID, cfg.LineupFixtures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lineups: %v", err)
+	}
+	var fixtureIDs []int
+	for rows.Next() {
+		var fixtureID int
+		if err := rows.Scan(&fixtureID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan lineup: %v", err)
+		}
+		fixtureIDs = append(fixtureIDs, fixtureID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(fixtureIDs) == 0 {
+		return nil, nil
+	}
+
+	t := &teamPlayers{values: map[int]playerValue{}}
+
+	query := `
+		SELECT fixtureId, player FROM lineupPlayers
+		WHERE team = ? AND starting = 1 AND fixtureId IN (` + recentLineupFixtures + `)
+	`
+	rows, err = db.Query(query, teamID, teamID, cfg.LineupFixtures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lineup players: %v", err)
+	}
+	starts := map[int]int{}
+	for rows.Next() {
+		var fixtureID, player int
+		if err := rows.Scan(&fixtureID, &player); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan lineup player: %v", err)
+		}
+		starts[player]++
+		if fixtureID == fixtureIDs[0] {
+			t.latest = append(t.latest, player)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// newest first, so a player keeps their latest name and position
+	query = `
+		SELECT s.player, p.name, s.position, s.minutes, s.rating
+		FROM playerStatistics s
+		JOIN fixtures f ON f.fixtureId = s.fixtureId
+		LEFT JOIN players p ON p.id = s.player
+		WHERE s.team = ? AND s.minutes > 0 AND s.rating IS NOT NULL AND s.fixtureId IN (` + recentLineupFixtures + `)
+		ORDER BY f.date DESC, f.fixtureId DESC
+	`
+	rows, err = db.Query(query, teamID, teamID, cfg.LineupFixtures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load player statistics: %v", err)
+	}
+	defer rows.Close()
+
+	ratingMinutes := map[int]float64{}
+	var totalMinutes, totalRatingMinutes float64
+	for rows.Next() {
+		var player, minutes int
+		var name, position sql.NullString
+		var rating float64
+		if err := rows.Scan(&player, &name, &position, &minutes, &rating); err != nil {
+			return nil, fmt.Errorf("failed to scan player statistics: %v", err)
+		}
+
+		v, ok := t.values[player]
+		if !ok {
+			v = playerValue{ID: player, Name: name.String, Position: position.String}
+		}
+		v.Minutes += minutes
+		t.values[player] = v
+		ratingMinutes[player] += rating * float64(minutes)
+		totalMinutes += float64(minutes)
+		totalRatingMinutes += rating * float64(minutes)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if totalMinutes == 0 {
+		return nil, nil
+	}
+
+	average := totalRatingMinutes / totalMinutes
+	for player, v := range t.values {
+		v.Value = (ratingMinutes[player] + average*lineupPriorMinutes) / (float64(v.Minutes) + lineupPriorMinutes)
+		t.values[player] = v
+	}
+
+	for player := range starts {
+		t.regulars = append(t.regulars, player)
+	}
+	sort.Slice(t.regulars, func(i, j int) bool {
+		a, b := t.regulars[i], t.regulars[j]
+		if starts[a] != starts[b] {
+			return starts[a] > starts[b]
+		}
+		if t.values[a].Minutes != t.values[b].Minutes {
+			return t.values[a].Minutes > t.values[b].Minutes
+		}
+		return a < b
+	})
+	if len(t.regulars) == 0 {
+		return nil, nil
+	}
+	t.regulars = t.regulars[:min(len(t.regulars), 11)]
+
+	t.replacement = average
+	var benchMinutes, benchRatingMinutes float64
+	for player, v := range t.values {
+		if !slices.Contains(t.regulars, player) {
+			benchMinutes += float64(v.Minutes)
+			benchRatingMinutes += ratingMinutes[player]
+		}
+	}
+	if benchMinutes > 0 {
+		t.replacement = benchRatingMinutes / benchMinutes
+	}
+	return t, nil
+}
+
+func (t *teamPlayers) value(player int) playerValue {
+	if v, ok := t.values[player]; ok {
+		return v
+	}
+	return playerValue{ID: player, Value: t.replacement}
+}
+
+func (t *teamPlayers) averageValue(players []int) float64 {
+	var total float64
+	for _, player := range players {
+		total += t.value(player).Value
+	}
+	return total / float64(len(players))
+}
+
+// defensivePosition tells whether a player's value counts for the defence
+func defensivePosition(position string) bool {
+	return position == "G" || position == "D"
+}
+
+// adjust compares a starting XI with the regulars. Only the difference in
+// average value counts, so an XI of fewer than eleven known players works too.
+// Each missing regular is paired with a replacement, in the same position if
+// there is one, and the difference between the two goes to the defence if
+// the regular was a goalkeeper or defender and to the attack otherwise, so a
+// change of formation with players of equal value moves neither. The rest,
+// from players without a counterpart, is split by their positions. Attack and
+// Defence add up to Elo.
+func (t *teamPlayers) adjust(xi []int, source string) *lineupAdjustment {
+	a := &lineupAdjustment{
+		Source:       source,
+		Elo:          cfg.LineupWeight * (t.averageValue(xi) - t.averageValue(t.regulars)),
+		Missing:      []playerValue{},
+		Replacements: []playerValue{},
+		Unavailable:  []playerValue{},
+	}
+
+	for _, player := range t.regulars {
+		if !slices.Contains(xi, player) {
+			a.Missing = append(a.Missing, t.value(player))
+		}
+	}
+	for _, player := range xi {
+		if !slices.Contains(t.regulars, player) {
+			a.Replacements = append(a.Replacements, t.value(player))
+		}
+	}
+
+	// the difference of each pair, and of all pairs together
+	var pairedElo float64
+	paired := make([]bool, len(a.Replacements))
+	pair := func(missing playerValue, samePosition bool) bool {
+		for i, replacement := range a.Replacements {
+			if paired[i] || samePosition && replacement.Position != missing.Position {
+				continue
+			}
+			paired[i] = true
+			difference := cfg.LineupWeight * (replacement.Value - missing.Value) / float64(len(t.regulars))
+			pairedElo += difference
+			if defensivePosition(missing.Position) {
+				a.Defence += difference
+			}
+			return true
+		}
+		return false
+	}
+
+	var unpaired []playerValue
+	for _, missing := range a.Missing {
+		if !pair(missing, true) && !pair(missing, false) {
+			unpaired = append(unpaired, missing)
+		}
+	}
+	for i, replacement := range a.Replacements {
+		if !paired[i] {
+			unpaired = append(unpaired, replacement)
+		}
+	}
+
+	// the pairs account for all of Elo when the XI is as long as the
+	// regulars, otherwise the rest goes to the positions left unpaired
+	if len(unpaired) > 0 {
+		var defenders int
+		for _, v := range unpaired {
+			if defensivePosition(v.Position) {
+				defenders++
+			}
+		}
+		a.Defence += (a.Elo - pairedElo) * float64(defenders) / float64(len(unpaired))
+	}
+	a.Attack = a.Elo - a.Defence
+	return a
+}
+
+// parseXI reads a comma separated list of player ids or names
+func (t *teamPlayers) parseXI(value string) ([]int, error) {
+	var xi []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if id, err := strconv.Atoi(field); err == nil {
+			xi = append(xi, id)
+			continue
+		}
+
+		found := false
+		for id, v := range t.values {
+			if strings.EqualFold(v.Name, field) {
+				xi = append(xi, id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown player: %s", field)
+		}
+	}
+	if len(xi) == 0 {
+		return nil, fmt.Errorf("no players in starting XI")
+	}
+	return xi, nil
+}
+
+// expectedXI is the team's last starting XI without the unavailable players.
+// Each of them is replaced by the player with the most minutes who played in
+// the same position, or any position if nobody did.
+func (t *teamPlayers) expectedXI(unavailable []int) []int {
+	var xi, out []int
+	for _, player := range t.latest {
+		if slices.Contains(unavailable, player) {
+			out = append(out, player)
+		} else {
+			xi = append(xi, player)
+		}
+	}
+
+	var candidates []int
+	for player := range t.values {
+		candidates = append(candidates, player)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := t.values[candidates[i]], t.values[candidates[j]]
+		if a.Minutes != b.Minutes {
+			return a.Minutes > b.Minutes
+		}
+		return a.ID < b.ID
+	})
+
+	for _, player := range out {
+		position := t.value(player).Position
+		replacement := 0
+		for _, candidate := range candidates {
+			if slices.Contains(xi, candidate) || slices.Contains(unavailable, candidate) {
+				continue
+			}
+			if t.values[candidate].Position == position {
+				replacement = candidate
+				break
+			}
+			if replacement == 0 {
+				replacement = candidate
+			}
+		}
+		if replacement != 0 {
+			xi = append(xi, replacement)
+		}
+	}
+	return xi
+}
+
+// getUnavailablePlayers returns the players of a team that getDataFromAPI
+// stored as missing the fixture. Doubtful players are expected to play.
+func getUnavailablePlayers(fixtureID int, teamID int) ([]int, error) {
+	query := "SELECT player FROM injuries WHERE fixtureId = ? AND team = ? AND type = 'Missing Fixture'"
+	rows, err := db.Query(query, fixtureID, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load injuries: %v", err)
+	}
+	defer rows.Close()
+
+	var players []int
+	for rows.Next() {
+		var player int
+		if err := rows.Scan(&player); err != nil {
+			return nil, fmt.Errorf("failed to scan injury: %v", err)
+		}
+		players = append(players, player)
+	}
+	return players, rows.Err()
+}
+
+func getAnnouncedXI(fixtureID int, teamID int) ([]int, error) {
+	query := "SELECT player FROM lineupPlayers WHERE fixtureId = ? AND team = ? AND starting = 1"
+	rows, err := db.Query(query, fixtureID, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load announced lineup: %v", err)
+	}
+	defer rows.Close()
+
+	var xi []int
+	for rows.Next() {
+		var player int
+		if err := rows.Scan(&player); err != nil {
+			return nil, fmt.Errorf("failed to scan announced lineup: %v", err)
+		}
+		xi = append(xi, player)
+	}
+	return xi, rows.Err()
+}
+
+// getLineupAdjustment adjusts a team's ratings to the given XI, else to the
+// lineup stored for the fixture, else to the team's last lineup without the
+// players ruled out of the fixture. A team without stored lineups is not
+// adjusted.
+func getLineupAdjustment(teamID int, fixtureID int, xi string) (*lineupAdjustment, error) {
+	t, err := getTeamPlayers(teamID)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		if xi != "" {
+			return nil, fmt.Errorf("no lineups stored for %s", teamNames[teamID])
+		}
+		return nil, nil
+	}
+
+	if xi != "" {
+		players, err := t.parseXI(xi)
+		if err != nil {
+			return nil, err
+		}
+		return t.adjust(players, "announced"), nil
+	}
+	var unavailable []int
+	if fixtureID != 0 {
+		players, err := getAnnouncedXI(fixtureID, teamID)
+		if err != nil {
+			return nil, err
+		}
+		if len(players) > 0 {
+			return t.adjust(players, "announced"), nil
+		}
+
+		unavailable, err = getUnavailablePlayers(fixtureID, teamID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(t.latest) == 0 {
+		return nil, nil
+	}
+
+	a := t.adjust(t.expectedXI(unavailable), "expected")
+	for _, player := range unavailable {
+		a.Unavailable = append(a.Unavailable, t.value(player))
+	}
+	return a, nil
+}
+
+func lineupElo(a *lineupAdjustment) float64 {
+	if a == nil {
+		return 0
+	}
+	return a.Elo
+}
+
+// lineupStrengths returns the changes to a team's attack and defence
+func lineupStrengths(a *lineupAdjustment) (float64, float64) {
+	if a == nil {
+		return 0, 0
+	}
+	return a.Attack, a.Defence
+}
+
+// prediction is one fixture as seen by a rating engine. Team 1 is treated as
+// the home side. Only the elo engine rates attack and defence.
+type prediction struct {
+	HomeTeamID        int      `json:"homeTeamId"`
+	HomeTeam          string   `json:"homeTeam"`
+	AwayTeamID        int      `json:"awayTeamId"`
+	AwayTeam          string   `json:"awayTeam"`
+	Engine            string   `json:"engine"`
+	HomeWin           float64  `json:"homeWin"`
+	AwayWin           float64  `json:"awayWin"`
+	HomeWinLow        *float64 `json:"homeWinLow,omitempty"`
+	HomeWinHigh       *float64 `json:"homeWinHigh,omitempty"`
+	GoalDifference    *float64 `json:"goalDifference,omitempty"`
+	HomeAttack        float64  `json:"homeAttack,omitempty"`
+	HomeDefence       float64  `json:"homeDefence,omitempty"`
+	AwayAttack        float64  `json:"awayAttack,omitempty"`
+	AwayDefence       float64  `json:"awayDefence,omitempty"`
+	HomeExpectedGoals float64  `json:"homeExpectedGoals"`
+	AwayExpectedGoals float64  `json:"awayExpectedGoals"`
+	// with elo Outcomes come from the expected goals, with the other engines
+	// from the home win probability as in the backtest
+	Outcomes outcomeProbabilities `json:"outcomes"`
+	// only set when predicting for the starting XIs
+	HomeLineup *lineupAdjustment `json:"homeLineup,omitempty"`
+	AwayLineup *lineupAdjustment `json:"awayLineup,omitempty"`
+}
+
+// predictFixture predicts a fixture, adjusting the ratings of each team with
+// a lineup adjustment that is not nil. Only the ratings of the engine are
+// read, so an engine predicts even when the others have not been run.
+func predictFixture(engine string, team1ID int, team2ID int, homeLineup *lineupAdjustment, awayLineup *lineupAdjustment) (prediction, error) {
+	p := prediction{
+		HomeTeamID: team1ID,
+		HomeTeam:   teamNames[team1ID],
+		AwayTeamID: team2ID,
+		AwayTeam:   teamNames[team2ID],
+		Engine:     engine,
+		HomeLineup: homeLineup,
+		AwayLineup: awayLineup,
+	}
+	homeAdjustment := lineupElo(homeLineup)
+	awayAdjustment := lineupElo(awayLineup)
+
+	switch engine {
+	case "glicko2":
+		team1Chances, low, high, err := calculateGlickoChances(team1ID, team2ID, homeAdjustment-awayAdjustment)
+		if err != nil {
+			return p, err
+		}
+		p.HomeWin = team1Chances
+		p.AwayWin = 1 - team1Chances
+		p.HomeWinLow = &low
+		p.HomeWinHigh = &high
+	case "pi":
+		team1Chances, goalDifference, err := calculatePiChances(team1ID, team2ID, homeAdjustment-awayAdjustment)
+		if err != nil {
+			return p, err
+		}
+		p.HomeWin = team1Chances
+		p.AwayWin = 1 - team1Chances
+		p.GoalDifference = &goalDifference
+	case "elo":
+		team1Chances, team2Chances, err := calculateChances(team1ID, team2ID, homeAdjustment-awayAdjustment)
+		if err != nil {
+			return p, err
+		}
+		p.HomeWin = team1Chances
+		p.AwayWin = team2Chances
+	default:
+		return p, fmt.Errorf("unknown rating engine: %s", engine)
+	}
+
+	averageGoals, err := getAverageGoals()
+	if err != nil {
+		return p, err
+	}
+
+	if engine != "elo" {
+		p.HomeExpectedGoals, p.AwayExpectedGoals = engineExpectedGoals(impliedGoalDifference(p.HomeWin), averageGoals)
+		p.Outcomes = calcEngineOutcomes(p.HomeWin)
+		return p, nil
+	}
+
+	p.HomeAttack, p.HomeDefence, err = getAttackDefenceForTeam(team1ID)
+	if err != nil {
+		return p, err
+	}
+	p.AwayAttack, p.AwayDefence, err = getAttackDefenceForTeam(team2ID)
+	if err != nil {
+		return p, err
 	}
 
-	return calcChancesFromElo(team1Elo, team2Elo)
+	homeAttack, homeDefence := lineupStrengths(homeLineup)
+	awayAttack, awayDefence := lineupStrengths(awayLineup)
+	p.HomeAttack += homeAttack
+	p.HomeDefence += homeDefence
+	p.AwayAttack += awayAttack
+	p.AwayDefence += awayDefence
+
+	p.HomeExpectedGoals = calcExpectedGoals(p.HomeAttack, p.AwayDefence, averageGoals)
+	p.AwayExpectedGoals = calcExpectedGoals(p.AwayAttack, p.HomeDefence, averageGoals)
+	p.Outcomes = calcOutcomeProbabilities(p.HomeExpectedGoals, p.AwayExpectedGoals)
+	return p, nil
 }
 
+// fullProcess predicts one fixture and prints it, or collects it for
+// writeRecords when a machine-readable output format is selected
 func fullProcess(team1 string, team2 string) {
-	team1ID, found1 := reverseTeamData[strings.ToLower(team1)]
-	team2ID, found2 := reverseTeamData[strings.ToLower(team2)]
+	team1ID, err := resolveTeam(team1)
+	if err != nil {
+		skipFixture(team1, team2, err)
+		return
+	}
+	team2ID, err := resolveTeam(team2)
+	if err != nil {
+		skipFixture(team1, team2, err)
+		return
+	}
+
+	fixtureID := findUpcomingFixtureID(team1ID, team2ID)
+	var homeLineup, awayLineup *lineupAdjustment
+	if useLineups {
+		homeLineup, err = getLineupAdjustment(team1ID, fixtureID, "")
+		if err != nil {
+			log.Fatalf("Failed to adjust %s to its lineup: %v", team1, err)
+		}
+		awayLineup, err = getLineupAdjustment(team2ID, fixtureID, "")
+		if err != nil {
+			log.Fatalf("Failed to adjust %s to its lineup: %v", team2, err)
+		}
+	}
 
-	if !found1 || !found2 {
-		log.Fatalf("Team not found in the map")
+	p, err := predictFixture(ratingEngine, team1ID, team2ID, homeLineup, awayLineup)
+	if err != nil {
+		log.Fatalf("Failed to predict %s - %s: %v", team1, team2, err)
+	}
+
+	if outputFormat != "text" {
+		predictionRecords = append(predictionRecords, newPredictionRecord(fixtureID, p))
+		return
+	}
+	printPrediction(team1, team2, p)
+}
+
+// skipFixture keeps stdout parseable by reporting on stderr unless the output is text
+func skipFixture(team1 string, team2 string, err error) {
+	if outputFormat != "text" {
+		fmt.Fprintf(os.Stderr, "Skipping %s - %s: %v\n", team1, team2, err)
+		return
 	}
+	fmt.Printf("Skipping %s - %s: %v\n\n", team1, team2, err)
+}
 
-	team1Chances, team2Chances := calculateChances(team1ID, team2ID)
+func printPrediction(team1 string, team2 string, p prediction) {
+	if p.HomeWinLow != nil && p.HomeWinHigh != nil {
+		// The interval for team2 mirrors the one for team1
+		fmt.Printf("%s: %.2f%% (95%% interval %.2f%% - %.2f%%)\n", team1, p.HomeWin*100, *p.HomeWinLow*100, *p.HomeWinHigh*100)
+		fmt.Printf("%s: %.2f%% (95%% interval %.2f%% - %.2f%%)\n", team2, p.AwayWin*100, (1-*p.HomeWinHigh)*100, (1-*p.HomeWinLow)*100)
+	} else {
+		// Round to 2 decimal places
+		fmt.Printf("%s: %s%%\n", team1, fmt.Sprintf("%.2f", p.HomeWin*100))
+		fmt.Printf("%s: %s%%\n", team2, fmt.Sprintf("%.2f", p.AwayWin*100))
+	}
+	if p.GoalDifference != nil {
+		fmt.Printf("Predicted goal difference: %+.2f\n", *p.GoalDifference)
+	}
 
-	// Round to 2 decimal places
-	fmt.Printf("%s: %s%%\n", team1, fmt.Sprintf("%.2f", team1Chances*100))
-	fmt.Printf("%s: %s%%\n", team2, fmt.Sprintf("%.2f", team2Chances*100))
+	if p.Engine == "elo" {
+		fmt.Printf("%s: attack %.0f, defence %.0f, expected goals %.2f\n", team1, p.HomeAttack, p.HomeDefence, p.HomeExpectedGoals)
+		fmt.Printf("%s: attack %.0f, defence %.0f, expected goals %.2f\n", team2, p.AwayAttack, p.AwayDefence, p.AwayExpectedGoals)
+	} else {
+		fmt.Printf("Expected goals: %.2f - %.2f\n", p.HomeExpectedGoals, p.AwayExpectedGoals)
+	}
+	printLineupAdjustment(team1, p.HomeLineup)
+	printLineupAdjustment(team2, p.AwayLineup)
 	fmt.Printf("-----------------------------------\n\n")
 }
 
-var teamData = map[int]string{
-	606:  "lugano",
-	1013: "grasshoppers",
-	6653: "yverdon",
-	783:  "zurich",
-	2180: "winterthur",
-	1011: "st gallen",
-	565:  "young boys",
-	630:  "sion",
-	644:  "luzern",
-	2184: "servette",
-	1014: "lausanne",
-	551:  "basel",
+func printLineupAdjustment(team string, a *lineupAdjustment) {
+	if a == nil {
+		return
+	}
+	fmt.Printf("%s: %+.0f Elo for the %s XI (attack %+.0f, defence %+.0f)\n", team, a.Elo, a.Source, a.Attack, a.Defence)
+	for _, v := range a.Unavailable {
+		fmt.Printf("  unavailable %s (%s, %.2f)\n", playerName(v), v.Position, v.Value)
+	}
+	for _, v := range a.Missing {
+		fmt.Printf("  missing %s (%s, %.2f)\n", playerName(v), v.Position, v.Value)
+	}
+	for _, v := range a.Replacements {
+		fmt.Printf("  starting %s (%s, %.2f)\n", playerName(v), v.Position, v.Value)
+	}
 }
 
-var reverseTeamData map[string]int
+func playerName(v playerValue) string {
+	if v.Name == "" {
+		return fmt.Sprintf("player %d", v.ID)
+	}
+	return v.Name
+}
 
-func main() {
-	err := initDB()
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+// modelVersion is written with every machine-readable record. Bump it when
+// the rating weights, engines or prediction formulas change.
+const modelVersion = "2.3"
+
+// generatedAt is shared by every record of one run
+var generatedAt = time.Now().UTC().Format(time.RFC3339)
+
+// predictionRecord is the flat, stable form of a prediction used by the
+// json, ndjson and csv outputs. FixtureID is 0 for fixtures that are not
+// stored as upcoming.
+type predictionRecord struct {
+	FixtureID         int      `json:"fixtureId"`
+	ModelVersion      string   `json:"modelVersion"`
+	Engine            string   `json:"engine"`
+	GeneratedAt       string   `json:"generatedAt"`
+	HomeTeamID        int      `json:"homeTeamId"`
+	HomeTeam          string   `json:"homeTeam"`
+	AwayTeamID        int      `json:"awayTeamId"`
+	AwayTeam          string   `json:"awayTeam"`
+	HomeWin           float64  `json:"homeWin"`
+	AwayWin           float64  `json:"awayWin"`
+	HomeWinLow        *float64 `json:"homeWinLow"`
+	HomeWinHigh       *float64 `json:"homeWinHigh"`
+	GoalDifference    *float64 `json:"goalDifference"`
+	HomeExpectedGoals float64  `json:"homeExpectedGoals"`
+	AwayExpectedGoals float64  `json:"awayExpectedGoals"`
+	OutcomeHome       float64  `json:"outcomeHome"`
+	OutcomeDraw       float64  `json:"outcomeDraw"`
+	OutcomeAway       float64  `json:"outcomeAway"`
+	HomeLineupElo     *float64 `json:"homeLineupElo"`
+	AwayLineupElo     *float64 `json:"awayLineupElo"`
+}
+
+func newPredictionRecord(fixtureID int, p prediction) predictionRecord {
+	r := predictionRecord{
+		FixtureID:         fixtureID,
+		ModelVersion:      modelVersion,
+		Engine:            p.Engine,
+		GeneratedAt:       generatedAt,
+		HomeTeamID:        p.HomeTeamID,
+		HomeTeam:          p.HomeTeam,
+		AwayTeamID:        p.AwayTeamID,
+		AwayTeam:          p.AwayTeam,
+		HomeWin:           p.HomeWin,
+		AwayWin:           p.AwayWin,
+		HomeWinLow:        p.HomeWinLow,
+		HomeWinHigh:       p.HomeWinHigh,
+		GoalDifference:    p.GoalDifference,
+		HomeExpectedGoals: p.HomeExpectedGoals,
+		AwayExpectedGoals: p.AwayExpectedGoals,
+		OutcomeHome:       p.Outcomes.Home,
+		OutcomeDraw:       p.Outcomes.Draw,
+		OutcomeAway:       p.Outcomes.Away,
 	}
-	defer closeDB()
+	if p.HomeLineup != nil {
+		r.HomeLineupElo = &p.HomeLineup.Elo
+	}
+	if p.AwayLineup != nil {
+		r.AwayLineupElo = &p.AwayLineup.Elo
+	}
+	return r
+}
 
-	// Create a reverse lookup map
-	reverseTeamData = make(map[string]int)
-	for id, name := range teamData {
-		reverseTeamData[strings.ToLower(name)] = id
+type ratingRecord struct {
+	ModelVersion string `json:"modelVersion"`
+	GeneratedAt  string `json:"generatedAt"`
+	Rank         int    `json:"rank"`
+	teamRating
+}
+
+func findUpcomingFixtureID(homeTeamID int, awayTeamID int) int {
+	var fixtureID int
+	query := "SELECT fixtureId FROM upcomingFixtures WHERE homeTeam = ? AND awayTeam = ? ORDER BY date LIMIT 1"
+	if err := db.QueryRow(query, homeTeamID, awayTeamID).Scan(&fixtureID); err != nil {
+		return 0
 	}
+	return fixtureID
+}
 
-	fmt.Println("")
+// csvFields flattens a record into its json field names and values, so the
+// csv columns always match the json and ndjson keys
+func csvFields(v reflect.Value) ([]string, []string) {
+	var header []string
+	var row []string
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+
+		if field.Anonymous {
+			embeddedHeader, embeddedRow := csvFields(value)
+			header = append(header, embeddedHeader...)
+			row = append(row, embeddedRow...)
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		header = append(header, name)
+
+		if value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				row = append(row, "")
+				continue
+			}
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.Float64:
+			row = append(row, strconv.FormatFloat(value.Float(), 'f', -1, 64))
+		default:
+			row = append(row, fmt.Sprint(value.Interface()))
+		}
+	}
+
+	return header, row
+}
+
+// writeRecords writes records as a json array, one json object per line
+// (ndjson) or csv with a header row
+func writeRecords[T any](w io.Writer, format string, records []T) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if records == nil {
+			records = []T{}
+		}
+		return encoder.Encode(records)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		writer := csv.NewWriter(w)
+		var zero T
+		header, _ := csvFields(reflect.ValueOf(zero))
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		for _, record := range records {
+			_, row := csvFields(reflect.ValueOf(record))
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unknown output format: %s", format)
+}
+
+// removeOverround turns bookmaker odds into probabilities by scaling the
+// inverse odds so they sum to one
+func removeOverround(homeOdds float64, drawOdds float64, awayOdds float64) outcomeProbabilities {
+	total := 1/homeOdds + 1/drawOdds + 1/awayOdds
+	return outcomeProbabilities{
+		Home: 1 / homeOdds / total,
+		Draw: 1 / drawOdds / total,
+		Away: 1 / awayOdds / total,
+	}
+}
+
+// market is the consensus of all bookmakers on one fixture: their average
+// implied probabilities and the best price available for each outcome.
+// Each bookmaker's overround is removed before averaging; the best prices
+// come from different bookmakers and have no overround of their own to remove.
+type market struct {
+	Bookmakers    int
+	Probabilities outcomeProbabilities
+	BestOdds      outcomeProbabilities
+}
+
+// getMarketForFixture builds the market from every bookmaker, or only from
+// bookmaker if it is not empty
+func getMarketForFixture(fixtureID int, bookmaker string) (market, error) {
+	var m market
+
+	rows, err := db.Query("SELECT homeOdds, drawOdds, awayOdds FROM odds WHERE fixtureId = ? AND (? = '' OR bookmaker = ?)",
+		fixtureID, bookmaker, bookmaker)
+	if err != nil {
+		return m, fmt.Errorf("failed to load odds: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var homeOdds, drawOdds, awayOdds float64
+		if err := rows.Scan(&homeOdds, &drawOdds, &awayOdds); err != nil {
+			return m, fmt.Errorf("failed to scan odds: %v", err)
+		}
+
+		implied := removeOverround(homeOdds, drawOdds, awayOdds)
+		m.Probabilities.Home += implied.Home
+		m.Probabilities.Draw += implied.Draw
+		m.Probabilities.Away += implied.Away
+		m.BestOdds.Home = max(m.BestOdds.Home, homeOdds)
+		m.BestOdds.Draw = max(m.BestOdds.Draw, drawOdds)
+		m.BestOdds.Away = max(m.BestOdds.Away, awayOdds)
+		m.Bookmakers++
+	}
+	if err := rows.Err(); err != nil {
+		return m, err
+	}
+
+	if m.Bookmakers > 0 {
+		m.Probabilities.Home /= float64(m.Bookmakers)
+		m.Probabilities.Draw /= float64(m.Bookmakers)
+		m.Probabilities.Away /= float64(m.Bookmakers)
+	}
+	return m, nil
+}
+
+// valueBetRecord is an outcome where the model gives a higher probability
+// than the market. Edge is the difference in probability, ExpectedValue the
+// return per unit staked at the best odds if the model is right.
+type valueBetRecord struct {
+	FixtureID         int     `json:"fixtureId"`
+	ModelVersion      string  `json:"modelVersion"`
+	Engine            string  `json:"engine"`
+	GeneratedAt       string  `json:"generatedAt"`
+	Date              string  `json:"date"`
+	HomeTeam          string  `json:"homeTeam"`
+	AwayTeam          string  `json:"awayTeam"`
+	Outcome           string  `json:"outcome"`
+	ModelProbability  float64 `json:"modelProbability"`
+	MarketProbability float64 `json:"marketProbability"`
+	Edge              float64 `json:"edge"`
+	BestOdds          float64 `json:"bestOdds"`
+	ExpectedValue     float64 `json:"expectedValue"`
+	Bookmakers        int     `json:"bookmakers"`
+}
+
+// defaultMinEdge is the edge over the market a value bet needs unless the
+// -edge flag or the edge parameter asks for another
+const defaultMinEdge = 0.05
+
+// findValueBets compares the engine's 1X2 probabilities for every upcoming
+// fixture with the market and keeps the outcomes whose edge exceeds minEdge
+func findValueBets(engine string, lineups bool, minEdge float64) ([]valueBetRecord, error) {
+	fixtures, err := getUpcomingFixtures(engine, lineups)
+	if err != nil {
+		return nil, err
+	}
+
+	valueBets := []valueBetRecord{}
+	for _, f := range fixtures {
+		if f.Prediction == nil {
+			continue
+		}
+
+		m, err := getMarketForFixture(f.FixtureID, "")
+		if err != nil {
+			return nil, err
+		}
+		if m.Bookmakers == 0 {
+			continue
+		}
+
+		model := calcEngineOutcomes(f.Prediction.HomeWin)
+		outcomes := []struct {
+			name   string
+			model  float64
+			market float64
+			odds   float64
+		}{
+			{"home", model.Home, m.Probabilities.Home, m.BestOdds.Home},
+			{"draw", model.Draw, m.Probabilities.Draw, m.BestOdds.Draw},
+			{"away", model.Away, m.Probabilities.Away, m.BestOdds.Away},
+		}
+
+		for _, o := range outcomes {
+			edge := o.model - o.market
+			if edge <= minEdge {
+				continue
+			}
+
+			valueBets = append(valueBets, valueBetRecord{
+				FixtureID:         f.FixtureID,
+				ModelVersion:      modelVersion,
+				Engine:            f.Prediction.Engine,
+				GeneratedAt:       generatedAt,
+				Date:              f.Date,
+				HomeTeam:          f.Prediction.HomeTeam,
+				AwayTeam:          f.Prediction.AwayTeam,
+				Outcome:           o.name,
+				ModelProbability:  o.model,
+				MarketProbability: o.market,
+				Edge:              edge,
+				BestOdds:          o.odds,
+				ExpectedValue:     o.model*o.odds - 1,
+				Bookmakers:        m.Bookmakers,
+			})
+		}
+	}
+
+	sort.Slice(valueBets, func(i, j int) bool {
+		return valueBets[i].Edge > valueBets[j].Edge
+	})
+	return valueBets, nil
+}
+
+func outputValueBets(minEdge float64) error {
+	valueBets, err := findValueBets(ratingEngine, useLineups, minEdge)
+	if err != nil {
+		return err
+	}
+
+	if outputFormat != "text" {
+		return writeRecords(os.Stdout, outputFormat, valueBets)
+	}
+
+	if len(valueBets) == 0 {
+		fmt.Printf("No outcome beats the market by more than %.1f%%\n", minEdge*100)
+		return nil
+	}
+	for _, v := range valueBets {
+		fmt.Printf("%s %s - %s: %s model %.1f%% vs market %.1f%% (edge %+.1f%%, best odds %.2f, EV %+.1f%%)\n",
+			v.Date, v.HomeTeam, v.AwayTeam, v.Outcome, v.ModelProbability*100, v.MarketProbability*100, v.Edge*100, v.BestOdds, v.ExpectedValue*100)
+	}
+	return nil
+}
+
+// historicalBet is a backtest prediction of a played fixture together with
+// the odds that were available for it
+type historicalBet struct {
+	fixtureID     int
+	date          string
+	homeTeamID    int
+	awayTeamID    int
+	result        string
+	probabilities outcomeProbabilities
+	// market holds the fair probabilities the edge is measured against,
+	// odds the prices the bet is staked at
+	market outcomeProbabilities
+	odds   outcomeProbabilities
+}
+
+// getHistoricalBets joins the backtest predictions of an engine with the
+// stored odds, oldest fixture first. The fair market probabilities are the
+// average over all bookmakers with each one's overround removed, and bets
+// are staked at the best price of any bookmaker. With a bookmaker both come
+// from that bookmaker alone.
+func getHistoricalBets(engine string, bookmaker string) ([]historicalBet, error) {
+	query := `
+		SELECT b.fixtureId, b.date, f.homeTeam, f.awayTeam, f.homeTeamScore, f.awayTeamScore,
+			b.probabilityHome, b.probabilityDraw, b.probabilityAway
+		FROM backtestPredictions b
+		JOIN fixtures f ON f.fixtureId = b.fixtureId
+		WHERE b.engine = ?
+			AND EXISTS (SELECT 1 FROM odds o WHERE o.fixtureId = b.fixtureId AND (? = '' OR o.bookmaker = ?))
+		ORDER BY b.date, b.fixtureId
+	`
+	rows, err := db.Query(query, engine, bookmaker, bookmaker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load historical bets: %v", err)
+	}
+	defer rows.Close()
+
+	var bets []historicalBet
+	for rows.Next() {
+		var b historicalBet
+		var date sql.NullString
+		var homeTeamScore, awayTeamScore int
+
+		err := rows.Scan(&b.fixtureID, &date, &b.homeTeamID, &b.awayTeamID, &homeTeamScore, &awayTeamScore,
+			&b.probabilities.Home, &b.probabilities.Draw, &b.probabilities.Away)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan historical bet: %v", err)
+		}
+		b.date = date.String
+
+		switch {
+		case homeTeamScore > awayTeamScore:
+			b.result = "home"
+		case homeTeamScore < awayTeamScore:
+			b.result = "away"
+		default:
+			b.result = "draw"
+		}
+		bets = append(bets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range bets {
+		m, err := getMarketForFixture(bets[i].fixtureID, bookmaker)
+		if err != nil {
+			return nil, err
+		}
+		bets[i].market = m.Probabilities
+		bets[i].odds = m.BestOdds
+	}
+	return bets, nil
+}
+
+// stakingStrategy decides how much of the bankroll to put on a bet
+type stakingStrategy struct {
+	name string
+	// kellyFraction is 0 for flat staking
+	kellyFraction float64
+	flatStake     float64
+}
+
+func (s stakingStrategy) stake(bankroll float64, probability float64, odds float64) float64 {
+	if s.kellyFraction == 0 {
+		return min(s.flatStake, bankroll)
+	}
+
+	kelly := (probability*odds - 1) / (odds - 1)
+	return max(0, min(bankroll*kelly*s.kellyFraction, bankroll))
+}
+
+// ledgerRecord is one simulated bet
+type ledgerRecord struct {
+	Strategy     string  `json:"strategy"`
+	ModelVersion string  `json:"modelVersion"`
+	Engine       string  `json:"engine"`
+	GeneratedAt  string  `json:"generatedAt"`
+	FixtureID    int     `json:"fixtureId"`
+	Date         string  `json:"date"`
+	HomeTeam     string  `json:"homeTeam"`
+	AwayTeam     string  `json:"awayTeam"`
+	Outcome      string  `json:"outcome"`
+	Probability  float64 `json:"probability"`
+	Odds         float64 `json:"odds"`
+	Stake        float64 `json:"stake"`
+	Won          bool    `json:"won"`
+	Profit       float64 `json:"profit"`
+	Bankroll     float64 `json:"bankroll"`
+}
+
+type simulationSummary struct {
+	Strategy        string  `json:"strategy"`
+	Bets            int     `json:"bets"`
+	Staked          float64 `json:"staked"`
+	Profit          float64 `json:"profit"`
+	ROI             float64 `json:"roi"`
+	FinalBankroll   float64 `json:"finalBankroll"`
+	MaxDrawdown     float64 `json:"maxDrawdown"`
+	MaxDrawdownPart float64 `json:"maxDrawdownPart"`
+}
+
+// simulateBankroll bets on the outcome with the highest expected value of
+// every fixture where the model's probability beats the market's implied
+// probability by more than minEdge
+func simulateBankroll(bets []historicalBet, strategy stakingStrategy, startingBankroll float64, minEdge float64) ([]ledgerRecord, simulationSummary) {
+	summary := simulationSummary{Strategy: strategy.name}
+	bankroll := startingBankroll
+	peak := startingBankroll
+
+	var ledger []ledgerRecord
+	for _, b := range bets {
+		outcomes := []struct {
+			name        string
+			probability float64
+			implied     float64
+			odds        float64
+		}{
+			{"home", b.probabilities.Home, b.market.Home, b.odds.Home},
+			{"draw", b.probabilities.Draw, b.market.Draw, b.odds.Draw},
+			{"away", b.probabilities.Away, b.market.Away, b.odds.Away},
+		}
+
+		best := -1
+		bestValue := 0.0
+		for i, o := range outcomes {
+			value := o.probability*o.odds - 1
+			if o.probability-o.implied > minEdge && value > bestValue {
+				best = i
+				bestValue = value
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		o := outcomes[best]
+		stake := strategy.stake(bankroll, o.probability, o.odds)
+		if stake <= 0 {
+			continue
+		}
+
+		won := o.name == b.result
+		profit := -stake
+		if won {
+			profit = stake * (o.odds - 1)
+		}
+		bankroll += profit
+
+		summary.Bets++
+		summary.Staked += stake
+		summary.Profit += profit
+		peak = max(peak, bankroll)
+		summary.MaxDrawdown = max(summary.MaxDrawdown, peak-bankroll)
+		summary.MaxDrawdownPart = max(summary.MaxDrawdownPart, (peak-bankroll)/peak)
+
+		ledger = append(ledger, ledgerRecord{
+			Strategy:     strategy.name,
+			ModelVersion: modelVersion,
+			Engine:       ratingEngine,
+			GeneratedAt:  generatedAt,
+			FixtureID:    b.fixtureID,
+			Date:         b.date,
+			HomeTeam:     teamNames[b.homeTeamID],
+			AwayTeam:     teamNames[b.awayTeamID],
+			Outcome:      o.name,
+			Probability:  o.probability,
+			Odds:         o.odds,
+			Stake:        stake,
+			Won:          won,
+			Profit:       profit,
+			Bankroll:     bankroll,
+		})
+
+		if bankroll <= 0 {
+			break
+		}
+	}
+
+	summary.FinalBankroll = bankroll
+	if summary.Staked > 0 {
+		summary.ROI = summary.Profit / summary.Staked
+	}
+	return ledger, summary
+}
+
+// outputBankrollSimulation runs flat, full Kelly and fractional Kelly staking
+// over the backtest. Every format has a summary per strategy. With showLedger
+// text output prints every bet before the summaries, and the other formats
+// write the ledger of all three strategies instead of the summaries.
+func outputBankrollSimulation(startingBankroll float64, flatStake float64, kellyFraction float64, bookmaker string, minEdge float64, showLedger bool) error {
+	bets, err := getHistoricalBets(ratingEngine, bookmaker)
+	if err != nil {
+		return err
+	}
+	if len(bets) == 0 {
+		return fmt.Errorf("no backtest predictions with odds for %s, run createEloRanking -engine %s first", ratingEngine, ratingEngine)
+	}
+
+	strategies := []stakingStrategy{
+		{name: "flat", flatStake: flatStake},
+		{name: "kelly", kellyFraction: 1},
+		{name: fmt.Sprintf("kelly-%g", kellyFraction), kellyFraction: kellyFraction},
+	}
+
+	var ledger []ledgerRecord
+	var summaries []simulationSummary
+	for _, strategy := range strategies {
+		strategyLedger, summary := simulateBankroll(bets, strategy, startingBankroll, minEdge)
+		ledger = append(ledger, strategyLedger...)
+		summaries = append(summaries, summary)
+	}
+
+	if outputFormat != "text" {
+		if showLedger {
+			return writeRecords(os.Stdout, outputFormat, ledger)
+		}
+		return writeRecords(os.Stdout, outputFormat, summaries)
+	}
+
+	if showLedger {
+		for _, l := range ledger {
+			fmt.Printf("%-10s %s %s - %s: %s @ %.2f (p %.1f%%) stake %.2f -> %+.2f, bankroll %.2f\n",
+				l.Strategy, l.Date, l.HomeTeam, l.AwayTeam, l.Outcome, l.Odds, l.Probability*100, l.Stake, l.Profit, l.Bankroll)
+		}
+		fmt.Println("")
+	}
+
+	fmt.Printf("%d fixtures with odds, starting bankroll %.2f\n", len(bets), startingBankroll)
+	for _, s := range summaries {
+		fmt.Printf("%-10s %4d bets, staked %9.2f, profit %+9.2f, ROI %+6.1f%%, final %9.2f, max drawdown %.2f (%.1f%%)\n",
+			s.Strategy, s.Bets, s.Staked, s.Profit, s.ROI*100, s.FinalBankroll, s.MaxDrawdown, s.MaxDrawdownPart*100)
+	}
+	return nil
+}
+
+// benchmarkRecord compares the log-loss of the model's 1X2 probabilities
+// with the log-loss of the market's implied probabilities over the same
+// fixtures. A negative Difference means the model beat the market.
+type benchmarkRecord struct {
+	ModelVersion  string  `json:"modelVersion"`
+	Engine        string  `json:"engine"`
+	GeneratedAt   string  `json:"generatedAt"`
+	Group         string  `json:"group"`
+	Key           string  `json:"key"`
+	Fixtures      int     `json:"fixtures"`
+	ModelLogLoss  float64 `json:"modelLogLoss"`
+	MarketLogLoss float64 `json:"marketLogLoss"`
+	Difference    float64 `json:"difference"`
+}
+
+// outcomeLogLoss is the log-loss of a 1X2 forecast for the given result
+func outcomeLogLoss(p outcomeProbabilities, result string) float64 {
+	probability := p.Away
+	switch result {
+	case "home":
+		probability = p.Home
+	case "draw":
+		probability = p.Draw
+	}
+	return -math.Log(max(probability, 1e-15))
+}
+
+// getMarketBenchmark scores the backtest predictions of the engine and the
+// average implied probabilities of the last odds stored for each fixture,
+// overall and grouped by team, month and the model's home win probability
+func getMarketBenchmark() ([]benchmarkRecord, error) {
+	bets, err := getHistoricalBets(ratingEngine, "")
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[[2]string]*benchmarkRecord{}
+	var order [][2]string
+	add := func(group string, key string, modelLoss float64, marketLoss float64) {
+		k := [2]string{group, key}
+		r, ok := records[k]
+		if !ok {
+			r = &benchmarkRecord{
+				ModelVersion: modelVersion,
+				Engine:       ratingEngine,
+				GeneratedAt:  generatedAt,
+				Group:        group,
+				Key:          key,
+			}
+			records[k] = r
+			order = append(order, k)
+		}
+		r.Fixtures++
+		r.ModelLogLoss += modelLoss
+		r.MarketLogLoss += marketLoss
+	}
+
+	for _, b := range bets {
+		modelLoss := outcomeLogLoss(b.probabilities, b.result)
+		marketLoss := outcomeLogLoss(b.market, b.result)
+
+		month := "unknown"
+		if len(b.date) >= 7 {
+			month = b.date[:7]
+		}
+		bucket := min(int(b.probabilities.Home*10), 9)
+
+		add("overall", "all", modelLoss, marketLoss)
+		add("team", teamNames[b.homeTeamID], modelLoss, marketLoss)
+		add("team", teamNames[b.awayTeamID], modelLoss, marketLoss)
+		add("month", month, modelLoss, marketLoss)
+		add("bucket", fmt.Sprintf("%d-%d%%", bucket*10, (bucket+1)*10), modelLoss, marketLoss)
+	}
+
+	groups := map[string]int{"overall": 0, "team": 1, "month": 2, "bucket": 3}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i][0] != order[j][0] {
+			return groups[order[i][0]] < groups[order[j][0]]
+		}
+		// team names, months and buckets all sort by their text
+		return order[i][1] < order[j][1]
+	})
+
+	var benchmark []benchmarkRecord
+	for _, k := range order {
+		r := records[k]
+		r.ModelLogLoss /= float64(r.Fixtures)
+		r.MarketLogLoss /= float64(r.Fixtures)
+		r.Difference = r.ModelLogLoss - r.MarketLogLoss
+		benchmark = append(benchmark, *r)
+	}
+	return benchmark, nil
+}
+
+func outputMarketBenchmark() error {
+	benchmark, err := getMarketBenchmark()
+	if err != nil {
+		return err
+	}
+	if len(benchmark) == 0 {
+		return fmt.Errorf("no backtest predictions with odds for %s, run createEloRanking -engine %s first", ratingEngine, ratingEngine)
+	}
+
+	if outputFormat != "text" {
+		return writeRecords(os.Stdout, outputFormat, benchmark)
+	}
+
+	group := ""
+	for _, r := range benchmark {
+		if r.Group != group {
+			group = r.Group
+			fmt.Printf("\n%-25s %8s %8s %8s %8s\n", group, "fixtures", "model", "market", "diff")
+		}
+
+		verdict := "beat market"
+		if r.Difference > 0 {
+			verdict = "lost to market"
+		}
+		fmt.Printf("%-25s %8d %8.4f %8.4f %+8.4f  %s\n", r.Key, r.Fixtures, r.ModelLogLoss, r.MarketLogLoss, r.Difference, verdict)
+	}
+	return nil
+}
+
+func outputRatings() error {
+	ratings, err := getRatings(ratingEngine)
+	if err != nil {
+		return err
+	}
+
+	if outputFormat == "text" {
+		for i, r := range ratings {
+			switch ratingEngine {
+			case "elo":
+				fmt.Printf("%2d. %-25s %7.0f (attack %.0f, defence %.0f)\n", i+1, r.Team, r.Rating, *r.AttackElo, *r.DefenceElo)
+			case "glicko2":
+				fmt.Printf("%2d. %-25s %7.0f (deviation %.0f)\n", i+1, r.Team, r.Rating, *r.Deviation)
+			case "pi":
+				fmt.Printf("%2d. %-25s %7.2f (home %.2f, away %.2f)\n", i+1, r.Team, r.Rating, *r.HomeRating, *r.AwayRating)
+			}
+		}
+		return nil
+	}
+
+	records := make([]ratingRecord, 0, len(ratings))
+	for i, r := range ratings {
+		records = append(records, ratingRecord{ModelVersion: modelVersion, GeneratedAt: generatedAt, Rank: i + 1, teamRating: r})
+	}
+	return writeRecords(os.Stdout, outputFormat, records)
+}
+
+func outputUpcomingFixtures() error {
+	fixtures, err := getUpcomingFixtures(ratingEngine, useLineups)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fixtures {
+		if f.Prediction == nil {
+			skipFixture(teamNames[f.HomeTeamID], teamNames[f.AwayTeamID], errors.New(f.Error))
+			continue
+		}
+		if outputFormat == "text" {
+			fmt.Printf("%s %s\n", f.Date, f.Round)
+			printPrediction(f.Prediction.HomeTeam, f.Prediction.AwayTeam, *f.Prediction)
+			continue
+		}
+		predictionRecords = append(predictionRecords, newPredictionRecord(f.FixtureID, *f.Prediction))
+	}
+
+	if outputFormat == "text" {
+		return nil
+	}
+	return writeRecords(os.Stdout, outputFormat, predictionRecords)
+}
+
+// defaultAliases are the names the tracker used before teams came from the
+// API, plus common abbreviations. They are stored in teamAliases on startup and
+// more can be added to that table directly.
+var defaultAliases = map[string]int{
+	"lugano":       606,
+	"grasshoppers": 1013,
+	"gc":           1013,
+	"yverdon":      6653,
+	"zurich":       783,
+	"fcz":          783,
+	"winterthur":   2180,
+	"st gallen":    1011,
+	"fcsg":         1011,
+	"young boys":   565,
+	"yb":           565,
+	"sion":         630,
+	"luzern":       644,
+	"lucerne":      644,
+	"servette":     2184,
+	"lausanne":     1014,
+	"basel":        551,
+	"fcb":          551,
+}
+
+// insertDefaultAliases adds the aliases above, leaving ones already stored
+// alone so they can be corrected in the database
+func insertDefaultAliases() error {
+	for alias, team := range defaultAliases {
+		_, err := db.Exec("INSERT INTO teamAliases (alias, team) VALUES (?, ?) ON CONFLICT DO NOTHING", alias, team)
+		if err != nil {
+			return fmt.Errorf("failed to insert default alias: %v", err)
+		}
+	}
+	return nil
+}
+
+func levenshtein(a string, b string) int {
+	ar := []rune(a)
+	br := []rune(b)
+
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(br)]
+}
+
+// loadTeams builds the name lookup from the teams stored by getDataFromAPI
+// and the alias table. Keys are normalized with normalizeTeamName.
+func loadTeams() error {
+	rows, err := db.Query("SELECT id, name, code FROM teams")
+	if err != nil {
+		return fmt.Errorf("failed to load teams: %v", err)
+	}
+	defer rows.Close()
+
+	reverseTeamData = make(map[string]int)
+	teamNames = make(map[int]string)
+	for rows.Next() {
+		var id int
+		var name string
+		var code sql.NullString
+
+		if err := rows.Scan(&id, &name, &code); err != nil {
+			return fmt.Errorf("failed to scan team: %v", err)
+		}
+
+		teamNames[id] = name
+		reverseTeamData[normalizeTeamName(name)] = id
+		if key := normalizeTeamName(code.String); key != "" {
+			reverseTeamData[key] = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(teamNames) == 0 {
+		return fmt.Errorf("no teams stored, run getDataFromAPI first")
+	}
+
+	aliasRows, err := db.Query("SELECT alias, team FROM teamAliases")
+	if err != nil {
+		return fmt.Errorf("failed to load team aliases: %v", err)
+	}
+	defer aliasRows.Close()
+
+	for aliasRows.Next() {
+		var alias string
+		var id int
+
+		if err := aliasRows.Scan(&alias, &id); err != nil {
+			return fmt.Errorf("failed to scan team alias: %v", err)
+		}
+
+		// skip aliases for teams that are not in this league's registry
+		if _, ok := teamNames[id]; ok {
+			reverseTeamData[normalizeTeamName(alias)] = id
+		}
+	}
+	return aliasRows.Err()
+}
+
+type teamNotFoundError struct {
+	name        string
+	suggestions []string
+}
+
+func (e *teamNotFoundError) Error() string {
+	if len(e.suggestions) == 0 {
+		return fmt.Sprintf("team %q not found", e.name)
+	}
+	return fmt.Sprintf("team %q not found, did you mean: %s?", e.name, strings.Join(e.suggestions, ", "))
+}
+
+// resolveTeam looks a name up exactly, then falls back to the closest known
+// name by edit distance. Anything too far off or ambiguous returns a
+// teamNotFoundError with up to three suggestions.
+func resolveTeam(name string) (int, error) {
+	key := normalizeTeamName(name)
+	if id, ok := reverseTeamData[key]; ok {
+		return id, nil
+	}
+
+	// closest distance of any key, or any word of a key, belonging to each team
+	distances := make(map[int]int)
+	for candidate, id := range reverseTeamData {
+		distance := levenshtein(key, candidate)
+		for _, word := range strings.Fields(candidate) {
+			distance = min(distance, levenshtein(key, word))
+		}
+		if best, ok := distances[id]; !ok || distance < best {
+			distances[id] = distance
+		}
+	}
+
+	ids := make([]int, 0, len(distances))
+	for id := range distances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if distances[ids[i]] != distances[ids[j]] {
+			return distances[ids[i]] < distances[ids[j]]
+		}
+		return teamNames[ids[i]] < teamNames[ids[j]]
+	})
+
+	maxDistance := max(1, len([]rune(key))/4)
+	if len(ids) > 0 && distances[ids[0]] <= maxDistance {
+		if len(ids) == 1 || distances[ids[1]] > distances[ids[0]] {
+			return ids[0], nil
+		}
+	}
+
+	var suggestions []string
+	for i := 0; i < len(ids) && i < 3; i++ {
+		suggestions = append(suggestions, teamNames[ids[i]])
+	}
+	return 0, &teamNotFoundError{name: name, suggestions: suggestions}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	body := map[string]interface{}{"error": err.Error()}
+
+	var notFound *teamNotFoundError
+	if errors.As(err, &notFound) {
+		status = http.StatusNotFound
+		body["suggestions"] = notFound.suggestions
+	}
+
+	writeJSON(w, status, body)
+}
+
+// resolveTeamParam accepts either a team id or anything resolveTeam understands
+func resolveTeamParam(value string) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		if _, ok := teamNames[id]; ok {
+			return id, nil
+		}
+	}
+	return resolveTeam(value)
+}
+
+// ratingEngines are the engines createEloRanking can run
+var ratingEngines = []string{"elo", "glicko2", "pi"}
+
+// engineParam returns the engine asked for, or the -engine flag if none was
+func engineParam(r *http.Request) (string, error) {
+	engine := cmp.Or(r.URL.Query().Get("engine"), ratingEngine)
+	if !slices.Contains(ratingEngines, engine) {
+		return "", fmt.Errorf("unknown rating engine: %s", engine)
+	}
+	return engine, nil
+}
+
+func lineupsParam(r *http.Request) (bool, error) {
+	if value := r.URL.Query().Get("lineups"); value != "" {
+		lineups, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid lineups: %s", value)
+		}
+		return lineups, nil
+	}
+	return useLineups, nil
+}
+
+type team struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Code    string `json:"code"`
+	Country string `json:"country"`
+	Logo    string `json:"logo"`
+	Venue   string `json:"venue"`
+	City    string `json:"city"`
+}
+
+func getTeams() ([]team, error) {
+	rows, err := db.Query("SELECT id, name, code, country, logo, venue, city FROM teams ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load teams: %v", err)
+	}
+	defer rows.Close()
+
+	teams := []team{}
+	for rows.Next() {
+		var t team
+		var code, country, logo, venue, city sql.NullString
+
+		if err := rows.Scan(&t.ID, &t.Name, &code, &country, &logo, &venue, &city); err != nil {
+			return nil, fmt.Errorf("failed to scan team: %v", err)
+		}
+		t.Code = code.String
+		t.Country = country.String
+		t.Logo = logo.String
+		t.Venue = venue.String
+		t.City = city.String
+		teams = append(teams, t)
+	}
+
+	return teams, rows.Err()
+}
+
+// teamRating is a team's current rating on one engine. Only the fields of
+// that engine are set, the others are nil.
+type teamRating struct {
+	TeamID int    `json:"teamId"`
+	Team   string `json:"team"`
+	Engine string `json:"engine"`
+	// Rating is the combined Elo, the Glicko-2 rating or the mean of the
+	// home and away pi-ratings
+	Rating            float64  `json:"rating"`
+	GoalElo           *float64 `json:"goalElo"`
+	WinnerElo         *float64 `json:"winnerElo"`
+	TotalShotsElo     *float64 `json:"totalShotsElo"`
+	BallPossessionElo *float64 `json:"ballPossessionElo"`
+	ExpectedGoalsElo  *float64 `json:"expectedGoalsElo"`
+	AttackElo         *float64 `json:"attackElo"`
+	DefenceElo        *float64 `json:"defenceElo"`
+	// xG-for and xG-against strengths, nil without xG coverage
+	ExpectedGoalsAttackElo  *float64 `json:"expectedGoalsAttackElo"`
+	ExpectedGoalsDefenceElo *float64 `json:"expectedGoalsDefenceElo"`
+	Deviation               *float64 `json:"deviation"`
+	Volatility              *float64 `json:"volatility"`
+	HomeRating              *float64 `json:"homeRating"`
+	AwayRating              *float64 `json:"awayRating"`
+}
+
+// nullableFloat returns nil for NULL, so it is written as null
+func nullableFloat(value sql.NullFloat64) *float64 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Float64
+}
+
+// getRatings returns the current ratings of engine, strongest team first
+func getRatings(engine string) ([]teamRating, error) {
+	var query string
+	switch engine {
+	case "elo":
+		query = "SELECT team, goalElo, winnerElo, totalShotsElo, ballPossessionElo, expectedGoalsElo, attackElo, defenceElo, expectedGoalsAttackElo, expectedGoalsDefenceElo FROM elo"
+	case "glicko2":
+		query = "SELECT team, rating, deviation, volatility FROM glicko"
+	case "pi":
+		query = "SELECT team, homeRating, awayRating FROM piRatings"
+	default:
+		return nil, fmt.Errorf("unknown rating engine: %s", engine)
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ratings: %v", err)
+	}
+	defer rows.Close()
+
+	ratings := []teamRating{}
+	for rows.Next() {
+		r := teamRating{Engine: engine}
+
+		switch engine {
+		case "elo":
+			var goalElo, winnerElo, totalShotsElo, ballPossessionElo, attackElo, defenceElo float64
+			var expectedGoalsElo, expectedGoalsAttackElo, expectedGoalsDefenceElo sql.NullFloat64
+			err = rows.Scan(&r.TeamID, &goalElo, &winnerElo, &totalShotsElo, &ballPossessionElo, &expectedGoalsElo, &attackElo, &defenceElo, &expectedGoalsAttackElo, &expectedGoalsDefenceElo)
+			r.Rating = combineElo(goalElo, winnerElo, totalShotsElo, ballPossessionElo, expectedGoalsElo)
+			r.GoalElo, r.WinnerElo, r.TotalShotsElo, r.BallPossessionElo = &goalElo, &winnerElo, &totalShotsElo, &ballPossessionElo
+			r.AttackElo, r.DefenceElo = &attackElo, &defenceElo
+			r.ExpectedGoalsElo = nullableFloat(expectedGoalsElo)
+			r.ExpectedGoalsAttackElo = nullableFloat(expectedGoalsAttackElo)
+			r.ExpectedGoalsDefenceElo = nullableFloat(expectedGoalsDefenceElo)
+		case "glicko2":
+			var deviation, volatility float64
+			err = rows.Scan(&r.TeamID, &r.Rating, &deviation, &volatility)
+			r.Deviation, r.Volatility = &deviation, &volatility
+		case "pi":
+			var homeRating, awayRating float64
+			err = rows.Scan(&r.TeamID, &homeRating, &awayRating)
+			r.Rating = (homeRating + awayRating) / 2
+			r.HomeRating, r.AwayRating = &homeRating, &awayRating
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rating: %v", err)
+		}
+
+		r.Team = teamNames[r.TeamID]
+		ratings = append(ratings, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ratings, func(i, j int) bool {
+		return ratings[i].Rating > ratings[j].Rating
+	})
+	return ratings, nil
+}
+
+type ratingHistoryEntry struct {
+	FixtureID int     `json:"fixtureId"`
+	Date      string  `json:"date"`
+	Rating    float64 `json:"rating"`
+}
+
+// getRatingHistory returns the rating after each fixture as recorded by
+// createEloRanking. Elo history is on the raw scale, before normalization.
+func getRatingHistory(engine string, teamID int) ([]ratingHistoryEntry, error) {
+	query := "SELECT fixtureId, date, rating FROM ratingHistory WHERE engine = ? AND team = ? ORDER BY date, fixtureId"
+	rows, err := db.Query(query, engine, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rating history: %v", err)
+	}
+	defer rows.Close()
+
+	history := []ratingHistoryEntry{}
+	for rows.Next() {
+		var entry ratingHistoryEntry
+		var date sql.NullString
+
+		if err := rows.Scan(&entry.FixtureID, &date, &entry.Rating); err != nil {
+			return nil, fmt.Errorf("failed to scan rating history: %v", err)
+		}
+		entry.Date = date.String
+		history = append(history, entry)
+	}
+
+	return history, rows.Err()
+}
+
+type upcomingFixture struct {
+	FixtureID  int         `json:"fixtureId"`
+	Round      string      `json:"round"`
+	Date       string      `json:"date"`
+	HomeTeamID int         `json:"homeTeamId"`
+	AwayTeamID int         `json:"awayTeamId"`
+	Prediction *prediction `json:"prediction,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// getUpcomingFixtures returns the fixtures stored by getDataFromAPI that have
+// not been played yet, each with a prediction where the engine has ratings.
+// With lineups the ratings are adjusted to the announced or expected XIs.
+func getUpcomingFixtures(engine string, lineups bool) ([]upcomingFixture, error) {
+	query := "SELECT fixtureId, round, date, homeTeam, awayTeam FROM upcomingFixtures ORDER BY date, fixtureId"
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upcoming fixtures: %v", err)
+	}
+	defer rows.Close()
+
+	fixtures := []upcomingFixture{}
+	for rows.Next() {
+		var f upcomingFixture
+		var round, date sql.NullString
+
+		if err := rows.Scan(&f.FixtureID, &round, &date, &f.HomeTeamID, &f.AwayTeamID); err != nil {
+			return nil, fmt.Errorf("failed to scan upcoming fixture: %v", err)
+		}
+		f.Round = round.String
+		f.Date = date.String
+		fixtures = append(fixtures, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range fixtures {
+		var homeLineup, awayLineup *lineupAdjustment
+		if lineups {
+			homeLineup, err = getLineupAdjustment(fixtures[i].HomeTeamID, fixtures[i].FixtureID, "")
+			if err != nil {
+				return nil, err
+			}
+			awayLineup, err = getLineupAdjustment(fixtures[i].AwayTeamID, fixtures[i].FixtureID, "")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		p, err := predictFixture(engine, fixtures[i].HomeTeamID, fixtures[i].AwayTeamID, homeLineup, awayLineup)
+		if err != nil {
+			fixtures[i].Error = err.Error()
+			continue
+		}
+		fixtures[i].Prediction = &p
+	}
+	return fixtures, nil
+}
+
+type calibrationBucket struct {
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+	Predicted float64 `json:"predicted"`
+	Observed  float64 `json:"observed"`
+	Count     int     `json:"count"`
+}
+
+// getCalibration groups the backtest predictions of an engine into ten
+// buckets of predicted home win probability and compares each bucket's
+// average prediction with how often the home team actually won
+func getCalibration(engine string) ([]calibrationBucket, error) {
+	rows, err := db.Query("SELECT homeWin, outcome FROM backtestPredictions WHERE engine = ?", engine)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backtest predictions: %v", err)
+	}
+	defer rows.Close()
+
+	buckets := make([]calibrationBucket, 10)
+	for i := range buckets {
+		buckets[i].Low = float64(i) / 10
+		buckets[i].High = float64(i+1) / 10
+	}
+
+	for rows.Next() {
+		var homeWin float64
+		var outcome float64
+
+		if err := rows.Scan(&homeWin, &outcome); err != nil {
+			return nil, fmt.Errorf("failed to scan backtest prediction: %v", err)
+		}
+
+		i := min(int(homeWin*10), 9)
+		buckets[i].Predicted += homeWin
+		buckets[i].Observed += outcome
+		buckets[i].Count++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range buckets {
+		if buckets[i].Count > 0 {
+			buckets[i].Predicted /= float64(buckets[i].Count)
+			buckets[i].Observed /= float64(buckets[i].Count)
+		}
+	}
+	return buckets, nil
+}
+
+func handleTeams(w http.ResponseWriter, r *http.Request) {
+	teams, err := getTeams()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, teams)
+}
+
+func handleRatings(w http.ResponseWriter, r *http.Request) {
+	engine, err := engineParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ratings, err := getRatings(engine)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ratings)
+}
+
+func handleRatingHistory(w http.ResponseWriter, r *http.Request) {
+	engine, err := engineParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	teamID, err := resolveTeamParam(r.PathValue("team"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	history, err := getRatingHistory(engine, teamID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func handlePredict(w http.ResponseWriter, r *http.Request) {
+	home := r.URL.Query().Get("home")
+	away := r.URL.Query().Get("away")
+	if home == "" || away == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("home and away are required"))
+		return
+	}
+
+	homeID, err := resolveTeamParam(home)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	awayID, err := resolveTeamParam(away)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	engine, err := engineParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	lineups, err := lineupsParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// an XI given for either team adjusts both, the other to its expected XI
+	homeXI := r.URL.Query().Get("homeXI")
+	awayXI := r.URL.Query().Get("awayXI")
+	var homeLineup, awayLineup *lineupAdjustment
+	if lineups || homeXI != "" || awayXI != "" {
+		fixtureID := findUpcomingFixtureID(homeID, awayID)
+		homeLineup, err = getLineupAdjustment(homeID, fixtureID, homeXI)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		awayLineup, err = getLineupAdjustment(awayID, fixtureID, awayXI)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	p, err := predictFixture(engine, homeID, awayID, homeLineup, awayLineup)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func handleUpcomingFixtures(w http.ResponseWriter, r *http.Request) {
+	engine, err := engineParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	lineups, err := lineupsParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fixtures, err := getUpcomingFixtures(engine, lineups)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fixtures)
+}
+
+func handleValueBets(w http.ResponseWriter, r *http.Request) {
+	engine, err := engineParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	lineups, err := lineupsParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	minEdge := defaultMinEdge
+	if value := r.URL.Query().Get("edge"); value != "" {
+		minEdge, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid edge: %s", value))
+			return
+		}
+	}
+
+	valueBets, err := findValueBets(engine, lineups, minEdge)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, valueBets)
+}
+
+func handleCalibration(w http.ResponseWriter, r *http.Request) {
+	engine, err := engineParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	buckets, err := getCalibration(engine)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, buckets)
+}
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+func newServer() http.Handler {
+	dashboard, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		log.Fatalf("Failed to load dashboard: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /teams", handleTeams)
+	mux.HandleFunc("GET /ratings", handleRatings)
+	mux.HandleFunc("GET /ratings/{team}/history", handleRatingHistory)
+	mux.HandleFunc("GET /predict", handlePredict)
+	mux.HandleFunc("GET /fixtures/upcoming", handleUpcomingFixtures)
+	mux.HandleFunc("GET /value-bets", handleValueBets)
+	mux.HandleFunc("GET /backtest/calibration", handleCalibration)
+	mux.Handle("GET /", http.FileServer(http.FS(dashboard)))
+	return mux
+}
+
+var reverseTeamData map[string]int
+
+var teamNames map[int]string
+
+var ratingEngine string
+
+var outputFormat string
+
+var useLineups bool
+
+var predictionRecords []predictionRecord
+
+func main() {
+	flag.StringVar(&ratingEngine, "engine", "elo", "ratings to predict from: elo, glicko2 or pi")
+	flag.StringVar(&outputFormat, "format", "text", "output format: text, json, ndjson or csv")
+	flag.BoolVar(&useLineups, "lineups", false, "adjust the ratings to the announced or else expected starting XI of each team")
+	addr := flag.String("addr", ":8080", "address to listen on in serve mode")
+	minEdge := flag.Float64("edge", defaultMinEdge, "minimum probability edge over the market in valuebets and bankroll mode")
+	startingBankroll := flag.Float64("bankroll", 1000, "starting bankroll in bankroll mode")
+	flatStake := flag.Float64("stake", 10, "stake per bet for flat staking in bankroll mode")
+	kellyFraction := flag.Float64("kelly-fraction", 0.25, "fraction of the Kelly stake for fractional Kelly in bankroll mode")
+	bookmaker := flag.String("bookmaker", "", "only bet at this bookmaker's odds in bankroll mode, best odds of any bookmaker if empty")
+	showLedger := flag.Bool("ledger", false, "print every simulated bet in bankroll mode, in the json, csv and ndjson formats instead of the summaries")
+	flag.Parse()
+
+	switch outputFormat {
+	case "text", "json", "ndjson", "csv":
+	default:
+		log.Fatalf("Unknown output format: %s", outputFormat)
+	}
+
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	err := initDB(cfg.databaseConfig)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer closeDB()
+
+	if err := createTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+	if err := insertDefaultAliases(); err != nil {
+		log.Fatalf("Failed to insert default aliases: %v", err)
+	}
+
+	if err := loadTeams(); err != nil {
+		log.Fatalf("Failed to load teams: %v", err)
+	}
+
+	switch flag.Arg(0) {
+	case "serve":
+		log.Printf("Serving on %s", *addr)
+		log.Fatal(http.ListenAndServe(*addr, newServer()))
+	case "ratings":
+		if err := outputRatings(); err != nil {
+			log.Fatalf("Failed to output ratings: %v", err)
+		}
+		return
+	case "upcoming":
+		if err := outputUpcomingFixtures(); err != nil {
+			log.Fatalf("Failed to output upcoming fixtures: %v", err)
+		}
+		return
+	case "benchmark":
+		if err := outputMarketBenchmark(); err != nil {
+			log.Fatalf("Failed to benchmark against the market: %v", err)
+		}
+		return
+	case "bankroll":
+		if err := outputBankrollSimulation(*startingBankroll, *flatStake, *kellyFraction, *bookmaker, *minEdge, *showLedger); err != nil {
+			log.Fatalf("Failed to simulate bankroll: %v", err)
+		}
+		return
+	case "valuebets":
+		if err := outputValueBets(*minEdge); err != nil {
+			log.Fatalf("Failed to find value bets: %v", err)
+		}
+		return
+	}
+
+	if outputFormat == "text" {
+		fmt.Println("")
+	}
 
 	fullProcess("grasshoppers", "zurich")
 	fullProcess("luzern", "young boys")
@@ -122,4 +2575,10 @@ func main() {
 	fullProcess("basel", "st gallen")
 	fullProcess("yverdon", "lugano")
 	fullProcess("lausanne", "winterthur")
+
+	if outputFormat != "text" {
+		if err := writeRecords(os.Stdout, outputFormat, predictionRecords); err != nil {
+			log.Fatalf("Failed to write predictions: %v", err)
+		}
+	}
 }
@@ -0,0 +1,471 @@
+package main
+
+// The programs of this repository share package main, so the tests are run
+// together with the files of this program:
+//
+//	go test generateChances_test.go generateChances.go config.go database.go teams.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// seedDatabase opens a fresh SQLite database with three teams, of which
+// Grasshoppers and Servette are rated by every engine and play fixture 100
+// next, with odds from two bookmakers. Basel has no ratings.
+func seedDatabase(t *testing.T) {
+	t.Helper()
+
+	database := databaseConfig{Database: filepath.Join(t.TempDir(), "FootballTracker.db"), BusyTimeout: 1000}
+	if err := initDB(database); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeDB)
+
+	if err := createTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := insertDefaultAliases(); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []string{
+		"INSERT INTO teams (id, name, code) VALUES (1013, 'Grasshoppers', 'GRA'), (2184, 'Servette', 'SER'), (551, 'Basel', 'BAS')",
+		"INSERT INTO fixtures (fixtureId, homeTeam, awayTeam, homeTeamScore, awayTeamScore, date) VALUES " +
+			"(1, 1013, 2184, 2, 1, '2026-08-01T18:00:00+00:00'), (2, 2184, 1013, 1, 1, '2026-09-01T18:00:00+00:00')",
+		"INSERT INTO elo (team, goalElo, winnerElo, ballPossessionElo, totalShotsElo, attackElo, defenceElo) VALUES " +
+			"(1013, 1700, 1700, 1600, 1600, 1650, 1550), (2184, 1400, 1400, 1500, 1500, 1450, 1500)",
+		"INSERT INTO glicko (team, rating, deviation, volatility) VALUES (1013, 1650, 80, 0.06), (2184, 1450, 120, 0.06)",
+		"INSERT INTO piRatings (team, homeRating, awayRating) VALUES (1013, 0.6, 0.2), (2184, -0.1, -0.4)",
+		"INSERT INTO upcomingFixtures (fixtureId, homeTeam, awayTeam, round, date) VALUES (100, 1013, 2184, 'Regular Season - 10', '2026-10-20T18:00:00+00:00')",
+		"INSERT INTO odds (fixtureId, bookmaker, homeOdds, drawOdds, awayOdds) VALUES (100, 'A', 2.5, 3.4, 2.8), (100, 'B', 2.6, 3.3, 2.7)",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("failed to seed database: %v", err)
+		}
+	}
+
+	if err := loadTeams(); err != nil {
+		t.Fatal(err)
+	}
+	ratingEngine = "elo"
+	useLineups = false
+}
+
+// get requests path from the server and decodes the JSON body into v after
+// checking the status code
+func get(t *testing.T, server *httptest.Server, path string, status int, v interface{}) {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("GET %s: status %d, want %d: %s", path, resp.StatusCode, status, body)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Fatalf("GET %s: content type %q", path, got)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("GET %s: %v: %s", path, err, body)
+	}
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	seedDatabase(t)
+	server := httptest.NewServer(newServer())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPredict(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, engine := range ratingEngines {
+		var p prediction
+		get(t, server, "/predict?home=grasshoppers&away=servette&engine="+engine, http.StatusOK, &p)
+
+		if p.HomeTeamID != 1013 || p.AwayTeamID != 2184 || p.HomeTeam != "Grasshoppers" || p.Engine != engine {
+			t.Errorf("%s: wrong fixture in %+v", engine, p)
+		}
+		if p.HomeWin <= 0.5 || math.Abs(p.HomeWin+p.AwayWin-1) > 1e-9 {
+			t.Errorf("%s: home win %v, away win %v", engine, p.HomeWin, p.AwayWin)
+		}
+		if sum := p.Outcomes.Home + p.Outcomes.Draw + p.Outcomes.Away; math.Abs(sum-1) > 1e-6 {
+			t.Errorf("%s: outcomes add up to %v", engine, sum)
+		}
+		if p.HomeExpectedGoals <= p.AwayExpectedGoals {
+			t.Errorf("%s: expected goals %v - %v", engine, p.HomeExpectedGoals, p.AwayExpectedGoals)
+		}
+		if (p.HomeWinLow != nil) != (engine == "glicko2") || (p.GoalDifference != nil) != (engine == "pi") || (p.HomeAttack != 0) != (engine == "elo") {
+			t.Errorf("%s: engine specific fields in %+v", engine, p)
+		}
+	}
+
+	// teams are also found by id and by code
+	var p prediction
+	get(t, server, "/predict?home=SER&away=1013", http.StatusOK, &p)
+	if p.HomeTeamID != 2184 || p.AwayTeamID != 1013 {
+		t.Errorf("got %d - %d", p.HomeTeamID, p.AwayTeamID)
+	}
+}
+
+func TestPredictWithoutElo(t *testing.T) {
+	server := newTestServer(t)
+	if _, err := db.Exec("DELETE FROM elo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// glicko2 and pi only need their own ratings
+	for _, engine := range []string{"glicko2", "pi"} {
+		var p prediction
+		get(t, server, "/predict?home=grasshoppers&away=servette&engine="+engine, http.StatusOK, &p)
+
+		outcomes := calcEngineOutcomes(p.HomeWin)
+		if math.Abs(p.Outcomes.Home-outcomes.Home) > 1e-9 || math.Abs(p.Outcomes.Away-outcomes.Away) > 1e-9 {
+			t.Errorf("%s: outcomes %+v, want %+v", engine, p.Outcomes, outcomes)
+		}
+		if p.HomeExpectedGoals <= p.AwayExpectedGoals {
+			t.Errorf("%s: expected goals %v - %v", engine, p.HomeExpectedGoals, p.AwayExpectedGoals)
+		}
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	get(t, server, "/predict?home=grasshoppers&away=servette&engine=elo", http.StatusUnprocessableEntity, &body)
+}
+
+func TestPredictErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/predict?home=grasshoppers", http.StatusBadRequest},
+		{"/predict?home=grasshoppers&away=servette&lineups=maybe", http.StatusBadRequest},
+		{"/predict?home=grasshoppers&away=nowhere", http.StatusNotFound},
+		{"/predict?home=grasshoppers&away=basel", http.StatusUnprocessableEntity},
+		{"/predict?home=grasshoppers&away=servette&engine=trueskill", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		var body struct {
+			Error string `json:"error"`
+		}
+		get(t, server, test.path, test.status, &body)
+		if body.Error == "" {
+			t.Errorf("%s: no error message", test.path)
+		}
+	}
+}
+
+func TestRatings(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, engine := range ratingEngines {
+		var ratings []teamRating
+		get(t, server, "/ratings?engine="+engine, http.StatusOK, &ratings)
+
+		if len(ratings) != 2 {
+			t.Fatalf("%s: %d ratings, want 2", engine, len(ratings))
+		}
+		if ratings[0].TeamID != 1013 || ratings[0].Rating <= ratings[1].Rating {
+			t.Errorf("%s: not ordered strongest first: %+v", engine, ratings)
+		}
+		for _, r := range ratings {
+			if r.Engine != engine {
+				t.Errorf("%s: rating of engine %s", engine, r.Engine)
+			}
+			if (r.GoalElo != nil) != (engine == "elo") || (r.Deviation != nil) != (engine == "glicko2") || (r.HomeRating != nil) != (engine == "pi") {
+				t.Errorf("%s: fields of another engine set in %+v", engine, r)
+			}
+		}
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	get(t, server, "/ratings?engine=trueskill", http.StatusBadRequest, &body)
+	if !strings.Contains(body.Error, "trueskill") {
+		t.Errorf("error %q does not name the engine", body.Error)
+	}
+}
+
+func TestValueBets(t *testing.T) {
+	server := newTestServer(t)
+
+	var p prediction
+	get(t, server, "/predict?home=grasshoppers&away=servette", http.StatusOK, &p)
+	model := calcEngineOutcomes(p.HomeWin)
+
+	var valueBets []valueBetRecord
+	get(t, server, "/value-bets?edge=0", http.StatusOK, &valueBets)
+	if len(valueBets) == 0 {
+		t.Fatal("no value bets at an edge of 0")
+	}
+
+	fair := map[string]float64{}
+	for _, odds := range [][3]float64{{2.5, 3.4, 2.8}, {2.6, 3.3, 2.7}} {
+		m := removeOverround(odds[0], odds[1], odds[2])
+		fair["home"] += m.Home / 2
+		fair["draw"] += m.Draw / 2
+		fair["away"] += m.Away / 2
+	}
+	modelProbabilities := map[string]float64{"home": model.Home, "draw": model.Draw, "away": model.Away}
+	bestOdds := map[string]float64{"home": 2.6, "draw": 3.4, "away": 2.8}
+
+	for i, v := range valueBets {
+		if v.FixtureID != 100 || v.Engine != "elo" || v.Bookmakers != 2 {
+			t.Errorf("wrong value bet %+v", v)
+		}
+		if i > 0 && v.Edge > valueBets[i-1].Edge {
+			t.Errorf("value bets not ordered by edge")
+		}
+		if math.Abs(v.ModelProbability-modelProbabilities[v.Outcome]) > 1e-9 {
+			t.Errorf("%s: model probability %v, want %v", v.Outcome, v.ModelProbability, modelProbabilities[v.Outcome])
+		}
+		if math.Abs(v.MarketProbability-fair[v.Outcome]) > 1e-9 {
+			t.Errorf("%s: market probability %v, want %v", v.Outcome, v.MarketProbability, fair[v.Outcome])
+		}
+		if v.Edge <= 0 || math.Abs(v.Edge-(v.ModelProbability-v.MarketProbability)) > 1e-9 {
+			t.Errorf("%s: edge %v", v.Outcome, v.Edge)
+		}
+		if v.BestOdds != bestOdds[v.Outcome] {
+			t.Errorf("%s: best odds %v, want %v", v.Outcome, v.BestOdds, bestOdds[v.Outcome])
+		}
+	}
+
+	// nothing beats the market by 100%, which is an empty list and not null
+	valueBets = nil
+	get(t, server, "/value-bets?edge=1", http.StatusOK, &valueBets)
+	if valueBets == nil || len(valueBets) != 0 {
+		t.Errorf("got %v at an edge of 1", valueBets)
+	}
+
+	for _, path := range []string{"/value-bets?edge=lots", "/value-bets?engine=trueskill", "/value-bets?lineups=maybe"} {
+		var body struct {
+			Error string `json:"error"`
+		}
+		get(t, server, path, http.StatusBadRequest, &body)
+		if body.Error == "" {
+			t.Errorf("%s: no error message", path)
+		}
+	}
+}
+
+func TestHistoryAndCalibration(t *testing.T) {
+	server := newTestServer(t)
+
+	queries := []string{
+		"INSERT INTO ratingHistory (engine, fixtureId, date, team, rating) VALUES " +
+			"('glicko2', 1, '2026-08-01T18:00:00+00:00', 1013, 1550), ('glicko2', 2, '2026-09-01T18:00:00+00:00', 1013, 1650), " +
+			"('elo', 1, '2026-08-01T18:00:00+00:00', 1013, 1520)",
+		"INSERT INTO backtestPredictions (engine, fixtureId, date, homeWin, outcome) VALUES " +
+			"('glicko2', 1, '2026-08-01T18:00:00+00:00', 0.62, 1), ('glicko2', 2, '2026-09-01T18:00:00+00:00', 0.68, 0.5)",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var history []ratingHistoryEntry
+	get(t, server, "/ratings/grasshoppers/history?engine=glicko2", http.StatusOK, &history)
+	if len(history) != 2 || history[0].FixtureID != 1 || history[1].Rating != 1650 {
+		t.Errorf("got history %+v", history)
+	}
+
+	var buckets []calibrationBucket
+	get(t, server, "/backtest/calibration?engine=glicko2", http.StatusOK, &buckets)
+	if len(buckets) != 10 || buckets[6].Count != 2 || math.Abs(buckets[6].Predicted-0.65) > 1e-9 || buckets[6].Observed != 0.75 {
+		t.Errorf("got buckets %+v", buckets)
+	}
+
+	for _, path := range []string{"/ratings/grasshoppers/history?engine=trueskill", "/backtest/calibration?engine=trueskill"} {
+		var body struct {
+			Error string `json:"error"`
+		}
+		get(t, server, path, http.StatusBadRequest, &body)
+		if !strings.Contains(body.Error, "trueskill") {
+			t.Errorf("%s: error %q does not name the engine", path, body.Error)
+		}
+	}
+}
+
+// seedLineups gives Grasshoppers a regular XI of players 1 to 11 in the two
+// played fixtures, with defender 12 and forward 13 coming off the bench.
+// For fixture 100 defender 12 starts instead of defender 4 and forward 13
+// instead of forward 11. Servette host Grasshoppers in fixture 101, which
+// forward 11 misses injured.
+func seedLineups(t *testing.T) {
+	t.Helper()
+
+	positions := map[int]string{1: "G", 2: "D", 3: "D", 4: "D", 5: "D", 6: "M", 7: "M", 8: "M", 9: "M", 10: "F", 11: "F", 12: "D", 13: "F"}
+	queries := []string{
+		"INSERT INTO upcomingFixtures (fixtureId, homeTeam, awayTeam, date) VALUES (101, 2184, 1013, '2026-10-27T18:00:00+00:00')",
+		"INSERT INTO lineups (fixtureId, team, formation) VALUES (1, 1013, '4-4-2'), (2, 1013, '4-4-2'), (100, 1013, '4-4-2')",
+		"INSERT INTO injuries (fixtureId, team, player, type, reason) VALUES (101, 1013, 11, 'Missing Fixture', 'Knee Injury')",
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("failed to seed lineups: %v", err)
+		}
+	}
+
+	for player, position := range positions {
+		if _, err := db.Exec("INSERT INTO players (id, name) VALUES (?, ?)", player, fmt.Sprintf("Player %d", player)); err != nil {
+			t.Fatal(err)
+		}
+		for _, fixtureID := range []int{1, 2} {
+			minutes, rating := 90, 7.0
+			switch player {
+			case 12:
+				minutes, rating = 30, 6.0
+			case 13:
+				minutes, rating = 30, 8.0
+			}
+			starting := player <= 11
+			_, err := db.Exec("INSERT INTO lineupPlayers (fixtureId, team, player, position, starting) VALUES (?, 1013, ?, ?, ?)", fixtureID, player, position, starting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.Exec("INSERT INTO playerStatistics (fixtureId, team, player, minutes, rating, position, substitute) VALUES (?, 1013, ?, ?, ?, ?, ?)",
+				fixtureID, player, minutes, rating, position, !starting)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if player != 4 && player != 11 {
+			_, err := db.Exec("INSERT INTO lineupPlayers (fixtureId, team, player, position, starting) VALUES (100, 1013, ?, ?, ?)", player, position, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func playerIDs(players []playerValue) []int {
+	var ids []int
+	for _, p := range players {
+		ids = append(ids, p.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestPredictWithLineups(t *testing.T) {
+	server := newTestServer(t)
+	seedLineups(t)
+
+	var base, p prediction
+	get(t, server, "/predict?home=grasshoppers&away=servette", http.StatusOK, &base)
+	get(t, server, "/predict?home=grasshoppers&away=servette&lineups=true", http.StatusOK, &p)
+
+	a := p.HomeLineup
+	if a == nil || a.Source != "announced" {
+		t.Fatalf("home lineup %+v, want the announced one", a)
+	}
+	if p.AwayLineup != nil {
+		t.Errorf("Servette has no lineups but got %+v", p.AwayLineup)
+	}
+	if got := playerIDs(a.Missing); !slices.Equal(got, []int{4, 11}) {
+		t.Errorf("missing %v, want [4 11]", got)
+	}
+	if got := playerIDs(a.Replacements); !slices.Equal(got, []int{12, 13}) {
+		t.Errorf("replacements %v, want [12 13]", got)
+	}
+
+	// the weaker defender lowers the defence, the stronger forward raises
+	// the attack, and both add up to the change of the Elo
+	if a.Defence >= 0 || a.Attack <= 0 {
+		t.Errorf("attack %v, defence %v", a.Attack, a.Defence)
+	}
+	if math.Abs(a.Attack+a.Defence-a.Elo) > 1e-9 {
+		t.Errorf("attack %v and defence %v do not add up to %v", a.Attack, a.Defence, a.Elo)
+	}
+	if math.Abs(p.HomeAttack-base.HomeAttack-a.Attack) > 1e-9 || math.Abs(p.HomeDefence-base.HomeDefence-a.Defence) > 1e-9 {
+		t.Errorf("strengths %v/%v moved from %v/%v by more than %v/%v", p.HomeAttack, p.HomeDefence, base.HomeAttack, base.HomeDefence, a.Attack, a.Defence)
+	}
+	if p.AwayAttack != base.AwayAttack || p.AwayDefence != base.AwayDefence {
+		t.Errorf("Servette's strengths changed")
+	}
+	if p.HomeExpectedGoals <= base.HomeExpectedGoals {
+		t.Errorf("expected goals %v, not above %v", p.HomeExpectedGoals, base.HomeExpectedGoals)
+	}
+
+	// without an announced lineup the injured forward is replaced by the
+	// other forward
+	get(t, server, "/predict?home=servette&away=grasshoppers&lineups=true", http.StatusOK, &p)
+	a = p.AwayLineup
+	if a == nil || a.Source != "expected" {
+		t.Fatalf("away lineup %+v, want the expected one", a)
+	}
+	if got := playerIDs(a.Unavailable); !slices.Equal(got, []int{11}) {
+		t.Errorf("unavailable %v, want [11]", got)
+	}
+	if got := playerIDs(a.Replacements); !slices.Equal(got, []int{13}) {
+		t.Errorf("replacements %v, want [13]", got)
+	}
+	if math.Abs(a.Defence) > 1e-9 || a.Attack <= 0 {
+		t.Errorf("attack %v, defence %v", a.Attack, a.Defence)
+	}
+
+	// pi-ratings move by the goal difference the away XI's Elo is worth
+	var piBase, pi prediction
+	get(t, server, "/predict?home=servette&away=grasshoppers&engine=pi", http.StatusOK, &piBase)
+	get(t, server, "/predict?home=servette&away=grasshoppers&engine=pi&lineups=true", http.StatusOK, &pi)
+	chances, _ := calcChancesFromElo(-a.Elo, 0)
+	if shift := *pi.GoalDifference - *piBase.GoalDifference; shift >= 0 || math.Abs(shift-impliedGoalDifference(chances)) > 1e-9 {
+		t.Errorf("goal difference moved by %v for %v Elo", shift, a.Elo)
+	}
+}
+
+func TestAdjustFormationChange(t *testing.T) {
+	// a 4-4-2 of regulars valued 7, whose bench is valued 7 too
+	players := &teamPlayers{values: map[int]playerValue{}, replacement: 7}
+	positions := []string{"G", "D", "D", "D", "D", "M", "M", "M", "M", "F", "F", "F", "D"}
+	for i, position := range positions {
+		players.values[i+1] = playerValue{ID: i + 1, Position: position, Value: 7}
+		if i < 11 {
+			players.regulars = append(players.regulars, i+1)
+		}
+	}
+
+	// a 3-4-3 with forward 12 for defender 5 changes nothing
+	a := players.adjust([]int{1, 2, 3, 4, 6, 7, 8, 9, 10, 11, 12}, "announced")
+	if math.Abs(a.Elo) > 1e-9 || math.Abs(a.Attack) > 1e-9 || math.Abs(a.Defence) > 1e-9 {
+		t.Errorf("elo %v, attack %v, defence %v, want 0", a.Elo, a.Attack, a.Defence)
+	}
+
+	// a weaker forward for a defender counts against the defence
+	players.values[12] = playerValue{ID: 12, Position: "F", Value: 6}
+	a = players.adjust([]int{1, 2, 3, 4, 6, 7, 8, 9, 10, 11, 12}, "announced")
+	if a.Elo >= 0 || math.Abs(a.Defence-a.Elo) > 1e-9 || math.Abs(a.Attack) > 1e-9 {
+		t.Errorf("elo %v, attack %v, defence %v", a.Elo, a.Attack, a.Defence)
+	}
+
+	// an XI of ten without a better defender counts against the defence
+	players.values[5] = playerValue{ID: 5, Position: "D", Value: 8}
+	a = players.adjust([]int{1, 2, 3, 4, 6, 7, 8, 9, 10, 11}, "announced")
+	if a.Elo >= 0 || math.Abs(a.Attack) > 1e-9 || math.Abs(a.Defence-a.Elo) > 1e-9 {
+		t.Errorf("elo %v, attack %v, defence %v", a.Elo, a.Attack, a.Defence)
+	}
+}
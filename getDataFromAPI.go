@@ -1,56 +1,122 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/joho/godotenv"
-	_ "github.com/mattn/go-sqlite3"
 )
 
-func init() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+// config holds the settings of this program. The defaults below are
+// overridden by the config file, and the config file by the environment.
+type config struct {
+	databaseConfig `yaml:",inline"`
+	APIKey         string   `yaml:"apiKey"`
+	Leagues        []int    `yaml:"leagues"`
+	Seasons        []string `yaml:"seasons"`
 }
 
-var db *sql.DB
+var cfg = config{
+	databaseConfig: defaultDatabaseConfig,
+	Leagues:        []int{207}, // Swiss Super League
+	Seasons:        []string{"2024"},
+}
 
-func initDB() error {
-	var err error
-	db, err = sql.Open("sqlite3", "./FootballTracker.db")
-	if err != nil {
-		return fmt.Errorf("failed to open database: %v", err)
+// loadConfig reads config.yaml, or the file named by CONFIG_FILE, and then
+// the environment. Neither the config file nor .env have to exist.
+func loadConfig() error {
+	if err := readConfig(&cfg, &cfg.databaseConfig); err != nil {
+		return err
 	}
 
-	// Test the connection
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %v", err)
+	if value := os.Getenv("RAPIDAPI_KEY"); value != "" {
+		cfg.APIKey = value
+	}
+	if value := os.Getenv("LEAGUES"); value != "" {
+		cfg.Leagues = nil
+		for _, league := range strings.Split(value, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(league))
+			if err != nil {
+				return fmt.Errorf("invalid league %q in LEAGUES: %v", league, err)
+			}
+			cfg.Leagues = append(cfg.Leagues, id)
+		}
+	}
+	if value := os.Getenv("SEASONS"); value != "" {
+		cfg.Seasons = nil
+		for _, season := range strings.Split(value, ",") {
+			cfg.Seasons = append(cfg.Seasons, strings.TrimSpace(season))
+		}
 	}
 
+	if len(cfg.Leagues) == 0 || len(cfg.Seasons) == 0 {
+		return fmt.Errorf("at least one league and one season have to be configured")
+	}
 	return nil
 }
 
-func getDB() *sql.DB {
-	return db
+// rateLimiter hands out one API request per interval, shared by all workers
+// so adding workers never exceeds the plan's request rate
+type rateLimiter struct {
+	ticker *time.Ticker
+	tokens chan struct{}
+	done   chan struct{}
+}
+
+func newRateLimiter(interval time.Duration) *rateLimiter {
+	l := &rateLimiter{
+		ticker: time.NewTicker(interval),
+		tokens: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	// the first request does not have to wait
+	l.tokens <- struct{}{}
+
+	go func() {
+		for {
+			select {
+			case <-l.ticker.C:
+				select {
+				case l.tokens <- struct{}{}:
+				default:
+				}
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l
 }
 
-func closeDB() {
-	if db != nil {
-		db.Close()
+func (l *rateLimiter) wait(ctx context.Context) error {
+	select {
+	case <-l.tokens:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+func (l *rateLimiter) stop() {
+	l.ticker.Stop()
+	close(l.done)
+}
+
 func checkIfRowExists(table string, column string, value float64) (bool, error) {
+	if err := checkIdentifiers(table, column); err != nil {
+		return false, err
+	}
+
 	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ?", table, column)
 	var exists int
 	err := getDB().QueryRow(query, value).Scan(&exists)
@@ -62,80 +128,227 @@ func checkIfRowExists(table string, column string, value float64) (bool, error)
 	return true, nil
 }
 
-func enterDataIntoDB(table string, columns []string, data []interface{}) error {
-	// Create placeholders for the SQL query
-	placeholders := make([]string, len(columns))
-	for i := range placeholders {
-		placeholders[i] = "?"
+// apiGet waits for the rate limiter and fetches an API endpoint, such as
+// "teams?season=2024&league=207". Every request goes through here so the
+// limiter alone decides how fast the plan's quota is used. Errors the API
+// reports in the body, like an exceeded request limit, are returned as errors.
+func apiGet(ctx context.Context, limiter *rateLimiter, path string) (map[string]interface{}, error) {
+	if err := limiter.wait(ctx); err != nil {
+		return nil, err
 	}
 
-	// Construct the SQL query
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		table,
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "))
-
-	// Execute the query
-	_, err := getDB().Exec(query, data...)
-	if err != nil {
-		return fmt.Errorf("failed to insert data: %v", err)
-	}
-	return nil
-}
-
-func getFixturesForYear(year string) []interface{} {
-	url := fmt.Sprintf("https://v3.football.api-sports.io/fixtures?season=%s&league=207", year)
-	method := "GET"
-
 	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://v3.football.api-sports.io/"+path, nil)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 
-	apiKey := os.Getenv("RAPIDAPI_KEY")
-	req.Header.Add("x-rapidapi-key", apiKey)
+	req.Header.Add("x-rapidapi-key", cfg.APIKey)
 	req.Header.Add("x-rapidapi-host", "v3.football.api-sports.io")
 
 	res, err := client.Do(req)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 
 	var result map[string]interface{}
-	err = json.Unmarshal([]byte(string(body)), &result)
-	if err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		return nil
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("error unmarshaling JSON: %v", err)
 	}
 
-	response := result["response"].([]interface{})
-	return response
+	// errors is an empty list when there are none, and an object otherwise
+	if apiErrors, ok := result["errors"].(map[string]interface{}); ok && len(apiErrors) > 0 {
+		endpoint, _, _ := strings.Cut(path, "?")
+		return nil, fmt.Errorf("%s: %v", endpoint, apiErrors)
+	}
+	return result, nil
 }
 
-func PercentagetoFloat(percentage string) float64 {
-	percentage = strings.Replace(percentage, "%", "", -1)
-	value, err := strconv.ParseFloat(percentage, 64)
+// getAPIResponse fetches an endpoint and returns its response array
+func getAPIResponse(ctx context.Context, limiter *rateLimiter, path string) ([]interface{}, error) {
+	result, err := apiGet(ctx, limiter, path)
 	if err != nil {
-		fmt.Println("Error converting percentage to float:", err)
-		log.Fatal(err)
+		return nil, err
+	}
+
+	response, ok := result["response"].([]interface{})
+	if !ok {
+		endpoint, _, _ := strings.Cut(path, "?")
+		return nil, fmt.Errorf("unexpected %s response: %v", endpoint, result)
+	}
+	return response, nil
+}
+
+func getFixturesForYear(ctx context.Context, limiter *rateLimiter, league int, year string) ([]interface{}, error) {
+	return getAPIResponse(ctx, limiter, fmt.Sprintf("fixtures?season=%s&league=%d", year, league))
+}
+
+// getTeamsForYear returns the teams of a league and season. An error leaves
+// the stored teams alone instead of replacing them with an empty list.
+func getTeamsForYear(ctx context.Context, limiter *rateLimiter, league int, year string) ([]interface{}, error) {
+	return getAPIResponse(ctx, limiter, fmt.Sprintf("teams?season=%s&league=%d", year, league))
+}
+
+// noteTeams stores every team of the season, replacing the previous details
+// so renamed clubs and new logos are picked up.
+func noteTeams(teams []interface{}) {
+	query := "INSERT INTO teams (id, name, code, country, logo, venue, city) VALUES (?, ?, ?, ?, ?, ?, ?) " +
+		"ON CONFLICT (id) DO UPDATE SET name = excluded.name, code = excluded.code, country = excluded.country, " +
+		"logo = excluded.logo, venue = excluded.venue, city = excluded.city"
+
+	for _, teamEntry := range teams {
+		teamEntryMap, ok := teamEntry.(map[string]interface{})
+		if !ok {
+			fmt.Println("Error asserting team entry")
+			continue
+		}
+
+		team, ok := teamEntryMap["team"].(map[string]interface{})
+		if !ok {
+			fmt.Println("Error asserting team")
+			continue
+		}
+
+		teamID, ok := team["id"].(float64)
+		if !ok {
+			fmt.Println("Error asserting team ID")
+			continue
+		}
+		name, _ := team["name"].(string)
+		code, _ := team["code"].(string)
+		country, _ := team["country"].(string)
+		logo, _ := team["logo"].(string)
+
+		var venueName, venueCity string
+		if venue, ok := teamEntryMap["venue"].(map[string]interface{}); ok {
+			venueName, _ = venue["name"].(string)
+			venueCity, _ = venue["city"].(string)
+		}
+
+		_, err := getDB().Exec(query, teamID, name, code, country, logo, venueName, venueCity)
+		if err != nil {
+			fmt.Println("Error storing team:", err)
+			continue
+		}
+
+		fmt.Println(teamID, name, code)
+	}
+}
+
+// getOddsForYear fetches the pre-match Match Winner (1X2) odds of every
+// bookmaker. The endpoint is paginated, so all pages are collected; on an
+// error the pages fetched so far are returned with it.
+func getOddsForYear(ctx context.Context, limiter *rateLimiter, league int, year string) ([]interface{}, error) {
+	var odds []interface{}
+
+	for page := 1; ; page++ {
+		result, err := apiGet(ctx, limiter, fmt.Sprintf("odds?season=%s&league=%d&bet=1&page=%d", year, league, page))
+		if err != nil {
+			return odds, err
+		}
+
+		response, _ := result["response"].([]interface{})
+		odds = append(odds, response...)
+
+		paging, _ := result["paging"].(map[string]interface{})
+		total, _ := paging["total"].(float64)
+		if float64(page) >= total {
+			return odds, nil
+		}
 	}
-	return value
 }
 
-func filterDataFromFixtures(data []interface{}) (float64, float64, float64, float64, float64, float64) {
+// noteOdds stores the home, draw and away price of each bookmaker per
+// fixture, replacing older prices so the latest (closing) odds are kept.
+func noteOdds(odds []interface{}) {
+	query := "INSERT INTO odds (fixtureId, bookmaker, homeOdds, drawOdds, awayOdds, updated) VALUES (?, ?, ?, ?, ?, ?) " +
+		"ON CONFLICT (fixtureId, bookmaker) DO UPDATE SET homeOdds = excluded.homeOdds, drawOdds = excluded.drawOdds, " +
+		"awayOdds = excluded.awayOdds, updated = excluded.updated"
+
+	for _, entry := range odds {
+		entryMap, ok := entry.(map[string]interface{})
+		if !ok {
+			fmt.Println("Error asserting odds entry")
+			continue
+		}
+
+		fixture, ok := entryMap["fixture"].(map[string]interface{})
+		if !ok {
+			fmt.Println("Error asserting odds fixture")
+			continue
+		}
+		fixtureID, ok := fixture["id"].(float64)
+		if !ok {
+			fmt.Println("Error asserting odds fixture ID")
+			continue
+		}
+		updated, _ := entryMap["update"].(string)
+
+		bookmakers, _ := entryMap["bookmakers"].([]interface{})
+		for _, bookmaker := range bookmakers {
+			bookmakerMap, ok := bookmaker.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			bookmakerName, _ := bookmakerMap["name"].(string)
+
+			bets, _ := bookmakerMap["bets"].([]interface{})
+			for _, bet := range bets {
+				betMap, ok := bet.(map[string]interface{})
+				if !ok || betMap["name"] != "Match Winner" {
+					continue
+				}
+
+				prices := make(map[string]float64)
+				values, _ := betMap["values"].([]interface{})
+				for _, value := range values {
+					valueMap, ok := value.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					outcome, _ := valueMap["value"].(string)
+					odd, _ := valueMap["odd"].(string)
+					price, err := strconv.ParseFloat(odd, 64)
+					if err != nil {
+						fmt.Println("Error converting odd to float:", err)
+						continue
+					}
+					prices[outcome] = price
+				}
+
+				if prices["Home"] == 0 || prices["Draw"] == 0 || prices["Away"] == 0 {
+					fmt.Println("Incomplete odds for fixture", fixtureID, bookmakerName)
+					continue
+				}
+
+				_, err := getDB().Exec(query, fixtureID, bookmakerName, prices["Home"], prices["Draw"], prices["Away"], updated)
+				if err != nil {
+					fmt.Println("Error storing odds:", err)
+				}
+			}
+		}
+	}
+}
+
+func PercentagetoFloat(percentage string) (float64, error) {
+	percentage = strings.Replace(percentage, "%", "", -1)
+	return strconv.ParseFloat(percentage, 64)
+}
+
+// filterDataFromFixtures picks the statistics of both teams out of the
+// statistics response. A statistic the API has no value for stays invalid
+// (NULL), while a real zero is kept.
+func filterDataFromFixtures(data []interface{}) (float64, sql.NullFloat64, sql.NullFloat64, sql.NullFloat64, float64, sql.NullFloat64, sql.NullFloat64, sql.NullFloat64) {
 	var team1Id, team2Id float64
-	var totalShots1, totalShots2 float64
-	var ballPossession1, ballPossession2 float64
+	var totalShots1, totalShots2 sql.NullFloat64
+	var ballPossession1, ballPossession2 sql.NullFloat64
+	var expectedGoals1, expectedGoals2 sql.NullFloat64
 
 	for i, teamData := range data {
 		teamMap, ok := teamData.(map[string]interface{})
@@ -170,19 +383,45 @@ func filterDataFromFixtures(data []interface{}) (float64, float64, float64, floa
 
 			switch statMap["type"] {
 			case "Total Shots":
-				shots, _ := statMap["value"].(float64)
+				shots, ok := statMap["value"].(float64)
+				if !ok {
+					continue
+				}
 				if i == 0 {
-					totalShots1 = shots
+					totalShots1 = sql.NullFloat64{Float64: shots, Valid: true}
 				} else {
-					totalShots2 = shots
+					totalShots2 = sql.NullFloat64{Float64: shots, Valid: true}
 				}
 			case "Ball Possession":
-				possession, _ := statMap["value"].(string)
-				possessionFloat := PercentagetoFloat(possession)
+				possession, ok := statMap["value"].(string)
+				if !ok {
+					continue
+				}
+				possessionFloat, err := PercentagetoFloat(possession)
+				if err != nil {
+					fmt.Println("Error converting percentage to float:", err)
+					continue
+				}
+				if i == 0 {
+					ballPossession1 = sql.NullFloat64{Float64: possessionFloat, Valid: true}
+				} else {
+					ballPossession2 = sql.NullFloat64{Float64: possessionFloat, Valid: true}
+				}
+			case "expected_goals":
+				// Only supplied for leagues with xG coverage, and sent as a string like "1.37"
+				xg, ok := statMap["value"].(string)
+				if !ok {
+					continue
+				}
+				xgFloat, err := strconv.ParseFloat(xg, 64)
+				if err != nil {
+					fmt.Println("Error converting expected goals to float:", err)
+					continue
+				}
 				if i == 0 {
-					ballPossession1 = possessionFloat
+					expectedGoals1 = sql.NullFloat64{Float64: xgFloat, Valid: true}
 				} else {
-					ballPossession2 = possessionFloat
+					expectedGoals2 = sql.NullFloat64{Float64: xgFloat, Valid: true}
 				}
 			}
 		}
@@ -194,59 +433,187 @@ func filterDataFromFixtures(data []interface{}) (float64, float64, float64, floa
 		}
 	}
 
-	return team1Id, totalShots1, ballPossession1, team2Id, totalShots2, ballPossession2
+	return team1Id, totalShots1, ballPossession1, expectedGoals1, team2Id, totalShots2, ballPossession2, expectedGoals2
 }
 
-func getAdditionalDataForFixture(fixtureId int) (float64, float64, float64, float64, float64, float64) {
-	url := fmt.Sprintf("https://v3.football.api-sports.io/fixtures/statistics?fixture=%d", fixtureId)
-	method := "GET"
+// fixtureStatistics are the per-team statistics of one played fixture
+type fixtureStatistics struct {
+	team1Id         float64
+	totalShots1     sql.NullFloat64
+	ballPossession1 sql.NullFloat64
+	expectedGoals1  sql.NullFloat64
+	team2Id         float64
+	totalShots2     sql.NullFloat64
+	ballPossession2 sql.NullFloat64
+	expectedGoals2  sql.NullFloat64
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, nil)
-	if err != nil {
-		fmt.Println(err)
-		log.Fatal(err)
+const (
+	coverageFull    = "full"
+	coveragePartial = "partial"
+	coverageNone    = "none"
+)
+
+// coverage tells whether shots and ball possession are known for both teams.
+// Expected goals are optional and do not count.
+func (s fixtureStatistics) coverage() string {
+	known := 0
+	for _, v := range []sql.NullFloat64{s.totalShots1, s.ballPossession1, s.totalShots2, s.ballPossession2} {
+		if v.Valid {
+			known++
+		}
 	}
 
-	apiKey := os.Getenv("RAPIDAPI_KEY")
-	req.Header.Add("x-rapidapi-key", apiKey)
-	req.Header.Add("x-rapidapi-host", "v3.football.api-sports.io")
+	switch known {
+	case 4:
+		return coverageFull
+	case 0:
+		return coverageNone
+	}
+	return coveragePartial
+}
 
-	res, err := client.Do(req)
+func getAdditionalDataForFixture(ctx context.Context, limiter *rateLimiter, fixtureId int) (fixtureStatistics, error) {
+	var stats fixtureStatistics
+
+	response, err := getAPIResponse(ctx, limiter, fmt.Sprintf("fixtures/statistics?fixture=%d", fixtureId))
 	if err != nil {
-		fmt.Println(err)
-		log.Fatal(err)
+		return stats, err
 	}
-	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	stats.team1Id, stats.totalShots1, stats.ballPossession1, stats.expectedGoals1,
+		stats.team2Id, stats.totalShots2, stats.ballPossession2, stats.expectedGoals2 = filterDataFromFixtures(response)
+
+	if coverage := stats.coverage(); coverage != coverageFull {
+		fmt.Printf("Warning: %s statistics coverage for fixture %d\n", coverage, fixtureId)
+	}
+
+	return stats, nil
+}
+
+// finishedStatuses are the API statuses of a fixture with a final result:
+// after regular time, after extra time and after a penalty shoot-out
+var finishedStatuses = map[string]bool{"FT": true, "AET": true, "PEN": true}
+
+// cancelledStatuses are fixtures that will not be played, or whose result is
+// awarded rather than played
+var cancelledStatuses = map[string]bool{"CANC": true, "ABD": true, "AWD": true, "WO": true}
+
+// noteUpcomingFixture keeps a fixture that has not been played yet so it can be
+// predicted. It is replaced on every run in case the kickoff moves.
+func noteUpcomingFixture(fixtureMap map[string]interface{}) {
+	// a missing or null object asserts to a nil map, which reads as empty
+	fixture, _ := fixtureMap["fixture"].(map[string]interface{})
+	league, _ := fixtureMap["league"].(map[string]interface{})
+	teams, _ := fixtureMap["teams"].(map[string]interface{})
+	homeTeam, _ := teams["home"].(map[string]interface{})
+	awayTeam, _ := teams["away"].(map[string]interface{})
+
+	fixtureID, ok := fixture["id"].(float64)
+	if !ok {
+		fmt.Println("Error asserting fixtureID")
+		return
+	}
+	date, _ := fixture["date"].(string)
+	round, _ := league["round"].(string)
+
+	homeTeamID, ok := homeTeam["id"].(float64)
+	if !ok {
+		fmt.Println("Error asserting homeTeamID")
+		return
+	}
+	awayTeamID, ok := awayTeam["id"].(float64)
+	if !ok {
+		fmt.Println("Error asserting awayTeamID")
+		return
+	}
+
+	query := "INSERT INTO upcomingFixtures (fixtureId, homeTeam, awayTeam, round, date) VALUES (?, ?, ?, ?, ?) " +
+		"ON CONFLICT (fixtureId) DO UPDATE SET homeTeam = excluded.homeTeam, awayTeam = excluded.awayTeam, " +
+		"round = excluded.round, date = excluded.date"
+	_, err := getDB().Exec(query, fixtureID, homeTeamID, awayTeamID, round, date)
 	if err != nil {
-		fmt.Println(err)
-		log.Fatal(err)
+		fmt.Println("Error storing upcoming fixture:", err)
 	}
+}
 
-	var result map[string]interface{}
-	err = json.Unmarshal([]byte(string(body)), &result)
+const (
+	jobPending = "pending"
+	jobFailed  = "failed"
+	jobDone    = "done"
+)
+
+// getJobStatus returns the backfill status of a played fixture. Fixtures
+// stored before backfill jobs existed count as done once their statistics
+// are there too; a fixture row without statistics is fetched again.
+func getJobStatus(fixtureID float64, season string) (string, error) {
+	var status string
+	err := getDB().QueryRow("SELECT status FROM backfillJobs WHERE fixtureId = ?", fixtureID).Scan(&status)
+	if err == nil {
+		return status, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	stored, err := checkIfRowExists("fixtures", "fixtureId", fixtureID)
+	if err != nil {
+		return "", err
+	}
+	hasStats, err := checkIfRowExists("totalShots", "fixtureId", fixtureID)
 	if err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		log.Fatal(err)
+		return "", err
 	}
 
-	response := result["response"].([]interface{})
+	status = jobPending
+	if stored && hasStats {
+		status = jobDone
+	}
 
-	team1Id, totalShots1, ballPossession1, team2Id, totalShots2, ballPossession2 := filterDataFromFixtures(response)
+	_, err = getDB().Exec("INSERT INTO backfillJobs (fixtureId, season, status, updated) VALUES (?, ?, ?, ?)",
+		fixtureID, season, status, time.Now().UTC().Format(time.RFC3339))
+	return status, err
+}
 
-	if team1Id == 0 || totalShots1 == 0 || ballPossession1 == 0 ||
-		team2Id == 0 || totalShots2 == 0 || ballPossession2 == 0 {
-		fmt.Println("Warning: One or more values are 0:")
-		fmt.Println(fixtureId)
-		log.Fatal(response)
+func markJobFailed(fixtureID float64, jobErr error) {
+	_, err := getDB().Exec("UPDATE backfillJobs SET status = ?, attempts = attempts + 1, error = ?, updated = ? WHERE fixtureId = ?",
+		jobFailed, jobErr.Error(), time.Now().UTC().Format(time.RFC3339), fixtureID)
+	if err != nil {
+		fmt.Println("Error updating backfill job:", err)
 	}
+}
+
+// playedFixture is a finished fixture from the fixture list whose backfill
+// job is not done yet
+type playedFixture struct {
+	fixtureID     float64
+	homeTeamID    float64
+	awayTeamID    float64
+	homeTeamScore float64
+	awayTeamScore float64
+	round         string
+	date          string
+	league        float64
+	season        string
+}
 
-	return team1Id, totalShots1, ballPossession1, team2Id, totalShots2, ballPossession2
+// fetchedFixture is a played fixture together with its statistics, or the
+// error that kept them from being fetched
+type fetchedFixture struct {
+	fixture playedFixture
+	stats   fixtureStatistics
+	err     error
 }
 
-func noteFixtures(fixtures []interface{}) {
+// noteFixtures stores upcoming fixtures and every played fixture whose
+// backfill job is not done yet. The statistics of played fixtures are fetched
+// by a pool of workers sharing the rate limiter, while a single writer stores
+// the results so SQLite only ever sees one writer. Cancelling ctx stops
+// fetching and keeps everything stored so far; pending and failed fixtures
+// are picked up again on the next run.
+func noteFixtures(ctx context.Context, season string, fixtures []interface{}, workers int, limiter *rateLimiter) {
+	var played []playedFixture
+
 	for _, fixture := range fixtures {
 		fixtureMap, ok := fixture.(map[string]interface{})
 		if !ok {
@@ -254,73 +621,914 @@ func noteFixtures(fixtures []interface{}) {
 			continue
 		}
 
-		fixtureID := fixtureMap["fixture"].(map[string]interface{})["id"].(float64)
+		fixture, ok := fixtureMap["fixture"].(map[string]interface{})
+		if !ok {
+			fmt.Println("Error asserting fixture")
+			continue
+		}
+		fixtureID, ok := fixture["id"].(float64)
+		if !ok {
+			fmt.Println("Error asserting fixtureID")
+			continue
+		}
+
+		// only a final result is stored, a match in progress or postponed
+		// stays upcoming until a later run sees it finished
+		fixtureStatus, _ := fixture["status"].(map[string]interface{})
+		short, _ := fixtureStatus["short"].(string)
+		switch {
+		case cancelledStatuses[short]:
+			fmt.Printf("Fixture %.0f will not be played (%s)\n", fixtureID, short)
+			if _, err := getDB().Exec("DELETE FROM upcomingFixtures WHERE fixtureId = ?", fixtureID); err != nil {
+				fmt.Println("Error removing upcoming fixture:", err)
+			}
+			continue
+		case !finishedStatuses[short]:
+			fmt.Printf("Fixture %.0f has not finished yet (%s)\n", fixtureID, short)
+			noteUpcomingFixture(fixtureMap)
+			continue
+		}
+
+		teams, _ := fixtureMap["teams"].(map[string]interface{})
+		homeTeam, _ := teams["home"].(map[string]interface{})
+		awayTeam, _ := teams["away"].(map[string]interface{})
+		goals, _ := fixtureMap["goals"].(map[string]interface{})
+		leagueMap, _ := fixtureMap["league"].(map[string]interface{})
+
+		homeTeamID, ok := homeTeam["id"].(float64)
+		if !ok {
+			fmt.Println("Error asserting homeTeamID")
+			continue
+		}
+		awayTeamID, ok := awayTeam["id"].(float64)
+		if !ok {
+			fmt.Println("Error asserting awayTeamID")
+			continue
+		}
+
+		homeTeamScore, ok := goals["home"].(float64)
+		if !ok {
+			fmt.Println("Error asserting homeTeamScore")
+			continue
+		}
+		awayTeamScore, ok := goals["away"].(float64)
+		if !ok {
+			fmt.Println("Error asserting awayTeamScore")
+			continue
+		}
 
-		exists, err := checkIfRowExists("fixtures", "fixtureId", fixtureID)
+		league, _ := leagueMap["id"].(float64)
+
+		status, err := getJobStatus(fixtureID, season)
 		if err != nil {
-			fmt.Println("Error checking if row exists:", err)
+			fmt.Println("Error reading backfill job:", err)
+			continue
+		}
+		if status == jobDone {
+			// fixtures stored before league and season were recorded get them here
+			_, err := getDB().Exec("UPDATE fixtures SET league = ?, season = ? WHERE fixtureId = ? AND league IS NULL", league, season, fixtureID)
+			if err != nil {
+				fmt.Println("Error updating fixture league:", err)
+			}
 			continue
 		}
 
-		if !exists {
-			if fixture, ok := fixtureMap["fixture"].(map[string]interface{}); ok {
-				if status, ok := fixture["status"].(map[string]interface{}); ok {
-					if short, ok := status["short"].(string); ok && short == "NS" {
-						fmt.Println("Game has not started yet")
-						continue
-					}
+		round, _ := leagueMap["round"].(string)
+		date, _ := fixture["date"].(string)
+
+		played = append(played, playedFixture{
+			fixtureID:     fixtureID,
+			homeTeamID:    homeTeamID,
+			awayTeamID:    awayTeamID,
+			homeTeamScore: homeTeamScore,
+			awayTeamScore: awayTeamScore,
+			round:         round,
+			date:          date,
+			league:        league,
+			season:        season,
+		})
+	}
+
+	if len(played) == 0 {
+		return
+	}
+	fmt.Printf("Fetching statistics for %d fixtures with %d workers\n", len(played), workers)
+
+	jobs := make(chan playedFixture)
+	results := make(chan fetchedFixture)
+
+	go func() {
+		defer close(jobs)
+		for _, f := range played {
+			select {
+			case jobs <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				stats, err := getAdditionalDataForFixture(ctx, limiter, int(f.fixtureID))
+				if err != nil && ctx.Err() != nil {
+					return
 				}
+				results <- fetchedFixture{fixture: f, stats: stats, err: err}
 			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	done := 0
+	for r := range results {
+		done++
+		if r.err != nil {
+			fmt.Printf("[%d/%d] Error fetching statistics for fixture %.0f: %v\n", done, len(played), r.fixture.fixtureID, r.err)
+			markJobFailed(r.fixture.fixtureID, r.err)
+			continue
+		}
+
+		if err := noteFixture(r.fixture, r.stats); err != nil {
+			fmt.Printf("[%d/%d] Error storing fixture %.0f: %v\n", done, len(played), r.fixture.fixtureID, err)
+			markJobFailed(r.fixture.fixtureID, err)
+			continue
+		}
+		fmt.Printf("[%d/%d] %.0f %.0f-%.0f %.0f:%.0f %s\n", done, len(played),
+			r.fixture.fixtureID, r.fixture.homeTeamID, r.fixture.awayTeamID, r.fixture.homeTeamScore, r.fixture.awayTeamScore, r.fixture.round)
+	}
+
+	if ctx.Err() != nil {
+		fmt.Printf("Cancelled after %d of %d fixtures\n", done, len(played))
+	}
+}
+
+// fixtureRow is one row written by noteFixture
+type fixtureRow struct {
+	table   string
+	columns []string
+	data    []interface{}
+}
+
+// noteFixture stores a played fixture with its score and statistics and
+// marks its backfill job done, all in one transaction. Rows left by an
+// earlier attempt are replaced, so storing a fixture twice is harmless.
+func noteFixture(f playedFixture, stats fixtureStatistics) error {
+	fixtureID := f.fixtureID
+
+	// without any statistics the response has no teams either
+	if stats.team1Id == 0 {
+		stats.team1Id = f.homeTeamID
+	}
+	if stats.team2Id == 0 {
+		stats.team2Id = f.awayTeamID
+	}
+
+	tx, err := getDB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"fixtures", "score", "totalShots", "ballPossession", "expectedGoals"} {
+		if err := checkIdentifiers(table, "fixtureId"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE fixtureId = ?", table), fixtureID); err != nil {
+			return fmt.Errorf("failed to clear %s: %v", table, err)
+		}
+	}
+
+	inserts := []fixtureRow{
+		{"fixtures", []string{"fixtureId", "homeTeam", "awayTeam", "homeTeamScore", "awayTeamScore", "round", "date", "statsCoverage", "league", "season"}, []interface{}{fixtureID, f.homeTeamID, f.awayTeamID, f.homeTeamScore, f.awayTeamScore, f.round, f.date, stats.coverage(), f.league, f.season}},
+		{"score", []string{"fixtureId", "team", "score"}, []interface{}{fixtureID, f.homeTeamID, f.homeTeamScore}},
+		{"score", []string{"fixtureId", "team", "score"}, []interface{}{fixtureID, f.awayTeamID, f.awayTeamScore}},
+		{"totalShots", []string{"fixtureId", "team", "totalShots"}, []interface{}{fixtureID, stats.team1Id, stats.totalShots1}},
+		{"ballPossession", []string{"fixtureId", "team", "ballPossession"}, []interface{}{fixtureID, stats.team1Id, stats.ballPossession1}},
+		{"totalShots", []string{"fixtureId", "team", "totalShots"}, []interface{}{fixtureID, stats.team2Id, stats.totalShots2}},
+		{"ballPossession", []string{"fixtureId", "team", "ballPossession"}, []interface{}{fixtureID, stats.team2Id, stats.ballPossession2}},
+	}
+
+	// xG rows are only stored when both teams have a value
+	if stats.expectedGoals1.Valid && stats.expectedGoals2.Valid {
+		inserts = append(inserts,
+			fixtureRow{"expectedGoals", []string{"fixtureId", "team", "expectedGoals"}, []interface{}{fixtureID, stats.team1Id, stats.expectedGoals1.Float64}},
+			fixtureRow{"expectedGoals", []string{"fixtureId", "team", "expectedGoals"}, []interface{}{fixtureID, stats.team2Id, stats.expectedGoals2.Float64}},
+		)
+	}
+
+	for _, insert := range inserts {
+		if err := enterDataIntoDB(tx, insert.table, insert.columns, insert.data); err != nil {
+			return fmt.Errorf("failed to store %s: %v", insert.table, err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM upcomingFixtures WHERE fixtureId = ?", fixtureID); err != nil {
+		return fmt.Errorf("failed to remove upcoming fixture: %v", err)
+	}
 
-			homeTeamID, ok := fixtureMap["teams"].(map[string]interface{})["home"].(map[string]interface{})["id"].(float64)
+	_, err = tx.Exec("UPDATE backfillJobs SET status = ?, attempts = attempts + 1, error = NULL, updated = ? WHERE fixtureId = ?",
+		jobDone, time.Now().UTC().Format(time.RFC3339), fixtureID)
+	if err != nil {
+		return fmt.Errorf("failed to update backfill job: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// getFixtureResponse fetches one of the per-fixture endpoints, such as
+// fixtures/lineups. An error means the fixture is tried again on the next run.
+func getFixtureResponse(ctx context.Context, limiter *rateLimiter, endpoint string, fixtureId int) ([]interface{}, error) {
+	return getAPIResponse(ctx, limiter, fmt.Sprintf("%s?fixture=%d", endpoint, fixtureId))
+}
+
+// nullNumber reads a number the API sends either as a number or as a string
+// like "7.3", with null and anything else becoming NULL
+func nullNumber(value interface{}) sql.NullFloat64 {
+	switch v := value.(type) {
+	case float64:
+		return sql.NullFloat64{Float64: v, Valid: true}
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return sql.NullFloat64{Float64: f, Valid: true}
+		}
+	}
+	return sql.NullFloat64{}
+}
+
+func nullString(value interface{}) sql.NullString {
+	s, ok := value.(string)
+	return sql.NullString{String: s, Valid: ok && s != ""}
+}
+
+// boolToInt stores flags as 0 and 1, which both SQLite and PostgreSQL accept
+// in an INTEGER column
+func boolToInt(value interface{}) int {
+	if b, ok := value.(bool); ok && b {
+		return 1
+	}
+	return 0
+}
+
+// lineupPlayer is a player of a starting XI or the bench
+type lineupPlayer struct {
+	id       float64
+	name     string
+	number   sql.NullFloat64
+	position sql.NullString
+	grid     sql.NullString
+	starting int
+}
+
+// teamLineup is the lineup one team announced for a fixture
+type teamLineup struct {
+	team      float64
+	formation sql.NullString
+	coach     sql.NullFloat64
+	players   []lineupPlayer
+}
+
+// playerStatistic is what one player did in a fixture
+type playerStatistic struct {
+	team          float64
+	player        float64
+	name          string
+	minutes       sql.NullFloat64
+	rating        sql.NullFloat64
+	position      sql.NullString
+	captain       int
+	substitute    int
+	goals         sql.NullFloat64
+	assists       sql.NullFloat64
+	shots         sql.NullFloat64
+	goalsConceded sql.NullFloat64
+	saves         sql.NullFloat64
+}
+
+// parseLineups reads the fixtures/lineups response, one entry per team
+func parseLineups(response []interface{}) []teamLineup {
+	var lineups []teamLineup
+
+	for _, entry := range response {
+		entryMap, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		team, _ := entryMap["team"].(map[string]interface{})
+		teamID, ok := team["id"].(float64)
+		if !ok {
+			fmt.Println("Error asserting lineup team ID")
+			continue
+		}
+		coach, _ := entryMap["coach"].(map[string]interface{})
+
+		lineup := teamLineup{
+			team:      teamID,
+			formation: nullString(entryMap["formation"]),
+			coach:     nullNumber(coach["id"]),
+		}
+
+		for _, group := range []struct {
+			key      string
+			starting int
+		}{{"startXI", 1}, {"substitutes", 0}} {
+			entries, _ := entryMap[group.key].([]interface{})
+			for _, e := range entries {
+				eMap, _ := e.(map[string]interface{})
+				player, _ := eMap["player"].(map[string]interface{})
+				id, ok := player["id"].(float64)
+				if !ok {
+					continue
+				}
+				name, _ := player["name"].(string)
+				lineup.players = append(lineup.players, lineupPlayer{
+					id:       id,
+					name:     name,
+					number:   nullNumber(player["number"]),
+					position: nullString(player["pos"]),
+					grid:     nullString(player["grid"]),
+					starting: group.starting,
+				})
+			}
+		}
+
+		lineups = append(lineups, lineup)
+	}
+
+	return lineups
+}
+
+// parsePlayerStatistics reads the fixtures/players response, which lists the
+// players of each team with a single statistics entry for the fixture
+func parsePlayerStatistics(response []interface{}) []playerStatistic {
+	var statistics []playerStatistic
+
+	for _, entry := range response {
+		entryMap, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		team, _ := entryMap["team"].(map[string]interface{})
+		teamID, ok := team["id"].(float64)
+		if !ok {
+			fmt.Println("Error asserting player statistics team ID")
+			continue
+		}
+
+		players, _ := entryMap["players"].([]interface{})
+		for _, p := range players {
+			pMap, _ := p.(map[string]interface{})
+			player, _ := pMap["player"].(map[string]interface{})
+			id, ok := player["id"].(float64)
 			if !ok {
-				fmt.Println("Error asserting homeTeamID")
 				continue
 			}
-			awayTeamID, ok := fixtureMap["teams"].(map[string]interface{})["away"].(map[string]interface{})["id"].(float64)
-			if !ok {
-				fmt.Println("Error asserting awayTeamID")
+			name, _ := player["name"].(string)
+
+			stats, _ := pMap["statistics"].([]interface{})
+			if len(stats) == 0 {
 				continue
 			}
+			statMap, _ := stats[0].(map[string]interface{})
+			games, _ := statMap["games"].(map[string]interface{})
+			goals, _ := statMap["goals"].(map[string]interface{})
+			shots, _ := statMap["shots"].(map[string]interface{})
 
-			homeTeamScore, ok := fixtureMap["goals"].(map[string]interface{})["home"].(float64)
-			if !ok {
-				fmt.Println("Error asserting homeTeamScore")
+			statistics = append(statistics, playerStatistic{
+				team:          teamID,
+				player:        id,
+				name:          name,
+				minutes:       nullNumber(games["minutes"]),
+				rating:        nullNumber(games["rating"]),
+				position:      nullString(games["position"]),
+				captain:       boolToInt(games["captain"]),
+				substitute:    boolToInt(games["substitute"]),
+				goals:         nullNumber(goals["total"]),
+				assists:       nullNumber(goals["assists"]),
+				shots:         nullNumber(shots["total"]),
+				goalsConceded: nullNumber(goals["conceded"]),
+				saves:         nullNumber(goals["saves"]),
+			})
+		}
+	}
+
+	return statistics
+}
+
+// fetchedLineups are the lineups and player statistics of a fixture, or the
+// error that kept them from being fetched
+type fetchedLineups struct {
+	fixtureID  float64
+	lineups    []teamLineup
+	statistics []playerStatistic
+	err        error
+}
+
+// getLineupsForFixture fetches both the lineups and the player statistics
+func getLineupsForFixture(ctx context.Context, fixtureID float64, limiter *rateLimiter) fetchedLineups {
+	result := fetchedLineups{fixtureID: fixtureID}
+
+	response, err := getFixtureResponse(ctx, limiter, "fixtures/lineups", int(fixtureID))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.lineups = parseLineups(response)
+
+	response, err = getFixtureResponse(ctx, limiter, "fixtures/players", int(fixtureID))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.statistics = parsePlayerStatistics(response)
+
+	return result
+}
+
+// getPendingLineups returns the stored fixtures of a league and season whose
+// lineups have not been stored yet. Imported fixtures, with negative ids, are
+// unknown to the API.
+func getPendingLineups(league int, season string) ([]float64, error) {
+	query := `
+		SELECT fixtureId FROM fixtures
+		WHERE fixtureId > 0 AND league = ? AND season = ?
+		AND fixtureId NOT IN (SELECT fixtureId FROM lineupJobs WHERE status = ?)
+		ORDER BY date, fixtureId
+	`
+	rows, err := getDB().Query(query, league, season, jobDone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending lineups: %v", err)
+	}
+	defer rows.Close()
+
+	var fixtureIDs []float64
+	for rows.Next() {
+		var fixtureID float64
+		if err := rows.Scan(&fixtureID); err != nil {
+			return nil, fmt.Errorf("failed to scan fixture: %v", err)
+		}
+		fixtureIDs = append(fixtureIDs, fixtureID)
+	}
+	return fixtureIDs, rows.Err()
+}
+
+// lineupCoverageDelay is how long after kickoff the API may still add the
+// lineups of a fixture. A fixture without lineups after that is in a league
+// without lineup coverage.
+const lineupCoverageDelay = 7 * 24 * time.Hour
+
+// playedWithin tells whether a stored fixture kicked off less than d ago. A
+// fixture without a readable date counts as long past.
+func playedWithin(fixtureID float64, d time.Duration) (bool, error) {
+	var date sql.NullString
+	if err := getDB().QueryRow("SELECT date FROM fixtures WHERE fixtureId = ?", fixtureID).Scan(&date); err != nil {
+		return false, fmt.Errorf("failed to load fixture date: %v", err)
+	}
+	kickoff, err := time.Parse(time.RFC3339, date.String)
+	if err != nil {
+		return false, nil
+	}
+	return time.Since(kickoff) < d, nil
+}
+
+// markLineupJob records the outcome of fetching the lineups of a fixture
+func markLineupJob(db execer, fixtureID float64, status string, jobErr error) error {
+	var message sql.NullString
+	if jobErr != nil {
+		message = sql.NullString{String: jobErr.Error(), Valid: true}
+	}
+
+	_, err := db.Exec("INSERT INTO lineupJobs (fixtureId, status, attempts, error, updated) VALUES (?, ?, 1, ?, ?) "+
+		"ON CONFLICT (fixtureId) DO UPDATE SET status = excluded.status, attempts = lineupJobs.attempts + 1, "+
+		"error = excluded.error, updated = excluded.updated",
+		fixtureID, status, message, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// noteLineups fetches the lineups and player statistics of every stored
+// fixture of the league and season that does not have them yet, with the
+// same worker pool and single writer as noteFixtures. Each fixture costs two
+// requests, on every run until the API has lineups or lineupCoverageDelay
+// has passed.
+func noteLineups(ctx context.Context, league int, season string, workers int, limiter *rateLimiter) {
+	pending, err := getPendingLineups(league, season)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+	fmt.Printf("Fetching lineups for %d fixtures with %d workers\n", len(pending), workers)
+
+	jobs := make(chan float64)
+	results := make(chan fetchedLineups)
+
+	go func() {
+		defer close(jobs)
+		for _, fixtureID := range pending {
+			select {
+			case jobs <- fixtureID:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fixtureID := range jobs {
+				result := getLineupsForFixture(ctx, fixtureID, limiter)
+				if result.err != nil && ctx.Err() != nil {
+					return
+				}
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	done := 0
+	for r := range results {
+		done++
+		if r.err != nil {
+			fmt.Printf("[%d/%d] Error fetching lineups for fixture %.0f: %v\n", done, len(pending), r.fixtureID, r.err)
+			if err := markLineupJob(getDB(), r.fixtureID, jobFailed, r.err); err != nil {
+				fmt.Println("Error updating lineup job:", err)
+			}
+			continue
+		}
+
+		// leagues without lineup coverage return nothing, which is final once
+		// the API has had time to add them, and retried on the next run before
+		empty := len(r.lineups) == 0 && len(r.statistics) == 0
+		if empty {
+			recent, err := playedWithin(r.fixtureID, lineupCoverageDelay)
+			if err != nil {
+				fmt.Printf("[%d/%d] Error: %v\n", done, len(pending), err)
 				continue
 			}
-			awayTeamScore, ok := fixtureMap["goals"].(map[string]interface{})["away"].(float64)
-			if !ok {
-				fmt.Println("Error asserting awayTeamScore")
+			if recent {
+				fmt.Printf("[%d/%d] %.0f no lineups yet\n", done, len(pending), r.fixtureID)
+				if err := markLineupJob(getDB(), r.fixtureID, jobPending, nil); err != nil {
+					fmt.Println("Error updating lineup job:", err)
+				}
 				continue
 			}
+		}
 
-			fmt.Println(fixtureID, homeTeamID, awayTeamID, homeTeamScore, awayTeamScore)
+		if err := noteLineup(r); err != nil {
+			fmt.Printf("[%d/%d] Error storing lineups of fixture %.0f: %v\n", done, len(pending), r.fixtureID, err)
+			if err := markLineupJob(getDB(), r.fixtureID, jobFailed, err); err != nil {
+				fmt.Println("Error updating lineup job:", err)
+			}
+			continue
+		}
+
+		if empty {
+			fmt.Printf("[%d/%d] %.0f no lineups\n", done, len(pending), r.fixtureID)
+			continue
+		}
+		fmt.Printf("[%d/%d] %.0f %d lineups, %d players\n", done, len(pending), r.fixtureID, len(r.lineups), len(r.statistics))
+	}
 
-			enterDataIntoDB("fixtures", []string{"fixtureId", "homeTeam", "awayTeam", "homeTeamScore", "awayTeamScore"}, []interface{}{fixtureID, homeTeamID, awayTeamID, homeTeamScore, awayTeamScore})
-			enterDataIntoDB("score", []string{"fixtureId", "team", "score"}, []interface{}{fixtureID, homeTeamID, homeTeamScore})
-			enterDataIntoDB("score", []string{"fixtureId", "team", "score"}, []interface{}{fixtureID, awayTeamID, awayTeamScore})
+	if ctx.Err() != nil {
+		fmt.Printf("Cancelled after %d of %d fixtures\n", done, len(pending))
+	}
+}
 
-			team1Id, totalShots1, ballPossession1, team2Id, totalShots2, ballPossession2 := getAdditionalDataForFixture(int(fixtureID))
-			enterDataIntoDB("totalShots", []string{"fixtureId", "team", "totalShots"}, []interface{}{fixtureID, team1Id, totalShots1})
-			enterDataIntoDB("ballPossession", []string{"fixtureId", "team", "ballPossession"}, []interface{}{fixtureID, team1Id, ballPossession1})
-			enterDataIntoDB("totalShots", []string{"fixtureId", "team", "totalShots"}, []interface{}{fixtureID, team2Id, totalShots2})
-			enterDataIntoDB("ballPossession", []string{"fixtureId", "team", "ballPossession"}, []interface{}{fixtureID, team2Id, ballPossession2})
+// noteLineup stores the lineups and player statistics of a fixture and marks
+// its lineup job done in one transaction, replacing an earlier attempt
+func noteLineup(r fetchedLineups) error {
+	tx, err := getDB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
 
-			fmt.Println(fixtureID, team1Id, totalShots1, ballPossession1, team2Id, totalShots2, ballPossession2)
-			fmt.Println("--------------------------------")
+	if err := storeLineups(tx, r); err != nil {
+		return err
+	}
+	if err := markLineupJob(tx, r.fixtureID, jobDone, nil); err != nil {
+		return fmt.Errorf("failed to update lineup job: %v", err)
+	}
 
-			time.Sleep(7 * time.Second)
+	return tx.Commit()
+}
+
+// storeLineups replaces the lineups, lineup players and player statistics
+// stored for a fixture
+func storeLineups(tx *sql.Tx, r fetchedLineups) error {
+	for _, table := range []string{"lineups", "lineupPlayers", "playerStatistics"} {
+		if err := checkIdentifiers(table, "fixtureId"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE fixtureId = ?", table), r.fixtureID); err != nil {
+			return fmt.Errorf("failed to clear %s: %v", table, err)
+		}
+	}
+
+	names := make(map[float64]string)
+	var inserts []fixtureRow
+	for _, lineup := range r.lineups {
+		inserts = append(inserts, fixtureRow{"lineups", []string{"fixtureId", "team", "formation", "coach"},
+			[]interface{}{r.fixtureID, lineup.team, lineup.formation, lineup.coach}})
+
+		for _, p := range lineup.players {
+			inserts = append(inserts, fixtureRow{"lineupPlayers", []string{"fixtureId", "team", "player", "number", "position", "grid", "starting"},
+				[]interface{}{r.fixtureID, lineup.team, p.id, p.number, p.position, p.grid, p.starting}})
+			names[p.id] = p.name
+		}
+	}
+	for _, s := range r.statistics {
+		inserts = append(inserts, fixtureRow{"playerStatistics",
+			[]string{"fixtureId", "team", "player", "minutes", "rating", "position", "captain", "substitute", "goals", "assists", "shots", "goalsConceded", "saves"},
+			[]interface{}{r.fixtureID, s.team, s.player, s.minutes, s.rating, s.position, s.captain, s.substitute, s.goals, s.assists, s.shots, s.goalsConceded, s.saves}})
+		names[s.player] = s.name
+	}
+
+	for _, insert := range inserts {
+		if err := enterDataIntoDB(tx, insert.table, insert.columns, insert.data); err != nil {
+			return fmt.Errorf("failed to store %s: %v", insert.table, err)
+		}
+	}
+
+	for id, name := range names {
+		_, err := tx.Exec("INSERT INTO players (id, name) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name", id, name)
+		if err != nil {
+			return fmt.Errorf("failed to store player %.0f: %v", id, err)
 		}
 	}
+	return nil
+}
+
+// getUpcomingFixtureIDs returns the upcoming fixtures that kick off within
+// the given time from now
+func getUpcomingFixtureIDs(within time.Duration) ([]float64, error) {
+	rows, err := getDB().Query("SELECT fixtureId, date FROM upcomingFixtures ORDER BY date, fixtureId")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upcoming fixtures: %v", err)
+	}
+	defer rows.Close()
+
+	var fixtureIDs []float64
+	for rows.Next() {
+		var fixtureID float64
+		var date sql.NullString
+		if err := rows.Scan(&fixtureID, &date); err != nil {
+			return nil, fmt.Errorf("failed to scan upcoming fixture: %v", err)
+		}
+
+		kickOff, err := time.Parse(time.RFC3339, date.String)
+		if err != nil {
+			continue
+		}
+		if until := time.Until(kickOff); until > 0 && until <= within {
+			fixtureIDs = append(fixtureIDs, fixtureID)
+		}
+	}
+	return fixtureIDs, rows.Err()
+}
+
+// noteAnnouncedLineups fetches the lineups of the upcoming fixtures that kick
+// off within window, so generateChances -lineups can use the announced XI
+// instead of the expected one. Teams announce them about an hour before
+// kick-off; until then the API returns nothing. No lineup job is recorded,
+// so the fixture's lineups and player statistics are fetched again once it
+// has been played.
+func noteAnnouncedLineups(ctx context.Context, limiter *rateLimiter, window time.Duration) {
+	fixtureIDs, err := getUpcomingFixtureIDs(window)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	for _, fixtureID := range fixtureIDs {
+		response, err := getFixtureResponse(ctx, limiter, "fixtures/lineups", int(fixtureID))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Error fetching announced lineups for fixture %.0f: %v\n", fixtureID, err)
+			continue
+		}
+
+		r := fetchedLineups{fixtureID: fixtureID, lineups: parseLineups(response)}
+		if len(r.lineups) == 0 {
+			fmt.Printf("%.0f lineups not announced yet\n", fixtureID)
+			continue
+		}
+
+		if err := noteAnnouncedLineup(r); err != nil {
+			fmt.Printf("Error storing announced lineups of fixture %.0f: %v\n", fixtureID, err)
+			continue
+		}
+		fmt.Printf("%.0f %d announced lineups\n", fixtureID, len(r.lineups))
+	}
+}
+
+func noteAnnouncedLineup(r fetchedLineups) error {
+	tx, err := getDB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := storeLineups(tx, r); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// injury is a player the API lists as missing or doubtful for a fixture
+type injury struct {
+	team       float64
+	player     float64
+	name       string
+	injuryType sql.NullString
+	reason     sql.NullString
+}
+
+// parseInjuries reads the injuries response, one entry per player
+func parseInjuries(response []interface{}) []injury {
+	var injuries []injury
+
+	for _, entry := range response {
+		entryMap, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		team, _ := entryMap["team"].(map[string]interface{})
+		player, _ := entryMap["player"].(map[string]interface{})
+		teamID, ok := team["id"].(float64)
+		if !ok {
+			continue
+		}
+		playerID, ok := player["id"].(float64)
+		if !ok {
+			continue
+		}
+		name, _ := player["name"].(string)
+
+		injuries = append(injuries, injury{
+			team:       teamID,
+			player:     playerID,
+			name:       name,
+			injuryType: nullString(player["type"]),
+			reason:     nullString(player["reason"]),
+		})
+	}
+
+	return injuries
+}
+
+// noteInjuries fetches the injured and suspended players of the upcoming
+// fixtures that kick off within window and replaces the ones stored before
+func noteInjuries(ctx context.Context, limiter *rateLimiter, window time.Duration) {
+	fixtureIDs, err := getUpcomingFixtureIDs(window)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	for _, fixtureID := range fixtureIDs {
+		response, err := getFixtureResponse(ctx, limiter, "injuries", int(fixtureID))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Error fetching injuries for fixture %.0f: %v\n", fixtureID, err)
+			continue
+		}
+
+		injuries := parseInjuries(response)
+		if err := noteFixtureInjuries(fixtureID, injuries); err != nil {
+			fmt.Printf("Error storing injuries of fixture %.0f: %v\n", fixtureID, err)
+			continue
+		}
+		fmt.Printf("%.0f %d injured or suspended players\n", fixtureID, len(injuries))
+	}
+}
+
+func noteFixtureInjuries(fixtureID float64, injuries []injury) error {
+	tx, err := getDB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM injuries WHERE fixtureId = ?", fixtureID); err != nil {
+		return fmt.Errorf("failed to clear injuries: %v", err)
+	}
+	for _, i := range injuries {
+		err := enterDataIntoDB(tx, "injuries", []string{"fixtureId", "team", "player", "type", "reason"},
+			[]interface{}{fixtureID, i.team, i.player, i.injuryType, i.reason})
+		if err != nil {
+			return fmt.Errorf("failed to store injury: %v", err)
+		}
+
+		_, err = tx.Exec("INSERT INTO players (id, name) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name", i.player, i.name)
+		if err != nil {
+			return fmt.Errorf("failed to store player %.0f: %v", i.player, err)
+		}
+	}
+
+	return tx.Commit()
 }
 
 func main() {
+	season := flag.String("season", "", "season to fetch, every configured season if empty")
+	workers := flag.Int("workers", 4, "number of fixtures whose statistics are fetched concurrently")
+	interval := flag.Duration("interval", 7*time.Second, "minimum time between two API requests of any kind, shared by all workers")
+	lineups := flag.Bool("lineups", false, "also fetch lineups, player statistics and injuries: two requests per played fixture, "+
+		"repeated on every run for a week while the API has none, and up to two per upcoming fixture within the windows")
+	lineupWindow := flag.Duration("lineup-window", 2*time.Hour, "with -lineups, fetch the announced lineups of upcoming fixtures that kick off within this time")
+	injuryWindow := flag.Duration("injury-window", 72*time.Hour, "with -lineups, fetch the injured and suspended players of upcoming fixtures that kick off within this time")
+	flag.Parse()
+
+	if *workers < 1 {
+		log.Fatalf("-workers must be at least 1")
+	}
+
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	seasons := cfg.Seasons
+	if *season != "" {
+		seasons = []string{*season}
+	}
+
+	// Ctrl-C stops fetching but lets the fixtures already fetched be stored
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	limiter := newRateLimiter(*interval)
+	defer limiter.stop()
+
 	fmt.Println("Starting program")
-	if err := initDB(); err != nil {
+	if err := initDB(cfg.databaseConfig); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer closeDB()
 
-	fixtures := getFixturesForYear("2024")
-	noteFixtures(fixtures)
+	if err := createTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+
+	for _, league := range cfg.Leagues {
+		for _, season := range seasons {
+			fmt.Printf("League %d, season %s\n", league, season)
+
+			teams, err := getTeamsForYear(ctx, limiter, league, season)
+			if err != nil {
+				fmt.Println("Error fetching teams:", err)
+			} else {
+				noteTeams(teams)
+			}
+
+			fixtures, err := getFixturesForYear(ctx, limiter, league, season)
+			if err != nil {
+				fmt.Println("Error fetching fixtures:", err)
+			} else {
+				noteFixtures(ctx, season, fixtures, *workers, limiter)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			if *lineups {
+				noteLineups(ctx, league, season, *workers, limiter)
+				if ctx.Err() != nil {
+					return
+				}
+			}
+
+			odds, err := getOddsForYear(ctx, limiter, league, season)
+			if err != nil {
+				fmt.Println("Error fetching odds:", err)
+			}
+			noteOdds(odds)
+		}
+	}
+
+	// upcoming fixtures are not stored per league, so they are done once
+	if *lineups {
+		noteAnnouncedLineups(ctx, limiter, *lineupWindow)
+		noteInjuries(ctx, limiter, *injuryWindow)
+	}
 }
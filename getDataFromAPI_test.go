@@ -0,0 +1,122 @@
+package main
+
+// The programs of this repository share package main, so the tests are run
+// together with the files of this program:
+//
+//	go test getDataFromAPI_test.go getDataFromAPI.go config.go database.go teams.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// seedDatabase opens a fresh SQLite database with every table
+func seedDatabase(t *testing.T) {
+	t.Helper()
+
+	database := databaseConfig{Database: filepath.Join(t.TempDir(), "FootballTracker.db"), BusyTimeout: 1000}
+	if err := initDB(database); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeDB)
+
+	if err := createTables(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoteFixturesWithMissingObjects(t *testing.T) {
+	seedDatabase(t)
+
+	// the API sends null for the teams of a fixture it has not paired yet,
+	// and may leave out goals and league entirely
+	var fixtures []interface{}
+	err := json.Unmarshal([]byte(`[
+		{"fixture": {"id": 1, "status": {"short": "NS"}}, "teams": null},
+		{"fixture": {"id": 2, "status": {"short": "FT"}}, "teams": null, "goals": null},
+		{"fixture": {"id": 3, "status": {"short": "FT"}}, "teams": {"home": {"id": 10}, "away": null}},
+		{"fixture": {"id": 4, "status": {"short": "FT"}}, "teams": {"home": {"id": 10}, "away": {"id": 11}}},
+		{"fixture": {"id": 5, "date": "2026-10-20T18:00:00+00:00", "status": {"short": "NS"}},
+		 "teams": {"home": {"id": 10}, "away": {"id": 11}}}
+	]`), &fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter := newRateLimiter(time.Millisecond)
+	defer limiter.stop()
+	noteFixtures(context.Background(), "2026", fixtures, 1, limiter)
+
+	// only the complete upcoming fixture is kept, and none was played
+	var fixtureID, upcoming, played int
+	if err := db.QueryRow("SELECT COUNT(*), MAX(fixtureId) FROM upcomingFixtures").Scan(&upcoming, &fixtureID); err != nil {
+		t.Fatal(err)
+	}
+	if upcoming != 1 || fixtureID != 5 {
+		t.Errorf("%d upcoming fixtures, the last %d, want only fixture 5", upcoming, fixtureID)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM fixtures").Scan(&played); err != nil {
+		t.Fatal(err)
+	}
+	if played != 0 {
+		t.Errorf("%d fixtures stored without teams or goals", played)
+	}
+
+	noteUpcomingFixture(map[string]interface{}{"fixture": nil, "league": nil, "teams": nil})
+}
+
+// emptyAPI answers every request with an empty response, like the API does
+// for fixtures it has no lineups for
+type emptyAPI struct{}
+
+func (emptyAPI) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := io.NopCloser(bytes.NewBufferString(`{"errors": [], "response": []}`))
+	return &http.Response{StatusCode: http.StatusOK, Body: body, Header: http.Header{}, Request: r}, nil
+}
+
+func TestNoteLineupsRetriesRecentFixtures(t *testing.T) {
+	seedDatabase(t)
+
+	transport := http.DefaultTransport
+	http.DefaultTransport = emptyAPI{}
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	// fixture 1 was played yesterday, fixture 2 a month ago
+	now := time.Now().UTC()
+	for fixtureID, played := range map[int]time.Time{1: now.AddDate(0, 0, -1), 2: now.AddDate(0, -1, 0)} {
+		_, err := db.Exec("INSERT INTO fixtures (fixtureId, homeTeam, awayTeam, homeTeamScore, awayTeamScore, date, league, season) VALUES (?, 10, 11, 1, 0, ?, 207, '2026')",
+			fixtureID, played.Format(time.RFC3339))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	limiter := newRateLimiter(time.Millisecond)
+	defer limiter.stop()
+	noteLineups(context.Background(), 207, "2026", 1, limiter)
+
+	// the API may still add the lineups of fixture 1, so it is fetched again
+	pending, err := getPendingLineups(207, "2026")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0] != 1 {
+		t.Errorf("pending lineups %v, want only fixture 1", pending)
+	}
+
+	noteLineups(context.Background(), 207, "2026", 1, limiter)
+	var status string
+	var attempts int
+	if err := db.QueryRow("SELECT status, attempts FROM lineupJobs WHERE fixtureId = 1").Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != jobPending || attempts != 2 {
+		t.Errorf("lineup job of fixture 1 is %s after %d attempts", status, attempts)
+	}
+}
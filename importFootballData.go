@@ -0,0 +1,502 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+// cfg holds the settings of this program, which has none besides the
+// database
+var cfg = defaultDatabaseConfig
+
+// loadTeamNames maps the normalized names, codes and aliases of the stored
+// teams to their API ids. Unlike the predictor there is no fuzzy matching: a
+// wrong guess would quietly corrupt the ratings of two teams.
+func loadTeamNames() (map[string]int, error) {
+	teams := make(map[string]int)
+
+	rows, err := db.Query("SELECT id, name, code FROM teams")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load teams: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name, code sql.NullString
+		if err := rows.Scan(&id, &name, &code); err != nil {
+			return nil, fmt.Errorf("failed to scan team: %v", err)
+		}
+		if key := normalizeTeamName(name.String); key != "" {
+			teams[key] = id
+		}
+		if key := normalizeTeamName(code.String); key != "" {
+			teams[key] = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	aliasRows, err := db.Query("SELECT alias, team FROM teamAliases")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load team aliases: %v", err)
+	}
+	defer aliasRows.Close()
+
+	for aliasRows.Next() {
+		var alias string
+		var id int
+		if err := aliasRows.Scan(&alias, &id); err != nil {
+			return nil, fmt.Errorf("failed to scan team alias: %v", err)
+		}
+		teams[normalizeTeamName(alias)] = id
+	}
+	return teams, aliasRows.Err()
+}
+
+// storeTeamMapping reads a csv of name,teamId lines into teamAliases, so the
+// mapping is kept for the next import and for the predictor
+func storeTeamMapping(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	for i, record := range records {
+		if len(record) != 2 {
+			return fmt.Errorf("%s line %d: expected name,teamId", path, i+1)
+		}
+		team, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			// a header line
+			if i == 0 {
+				continue
+			}
+			return fmt.Errorf("%s line %d: invalid team id %q", path, i+1, record[1])
+		}
+
+		_, err = db.Exec("INSERT INTO teamAliases (alias, team) VALUES (?, ?) ON CONFLICT (alias) DO UPDATE SET team = excluded.team",
+			normalizeTeamName(record[0]), team)
+		if err != nil {
+			return fmt.Errorf("failed to store alias %q: %v", record[0], err)
+		}
+	}
+	return nil
+}
+
+// bookmakers are the column prefixes of football-data.co.uk and the names
+// API-Football uses for the same bookmakers
+var bookmakers = []struct {
+	prefix string
+	name   string
+}{
+	{"B365", "Bet365"},
+	{"BW", "Bwin"},
+	{"IW", "Interwetten"},
+	{"LB", "Ladbrokes"},
+	{"PS", "Pinnacle"},
+	{"WH", "William Hill"},
+	{"VC", "VC Bet"},
+}
+
+// csvOdds are the 1X2 prices of one bookmaker
+type csvOdds struct {
+	bookmaker string
+	home      float64
+	draw      float64
+	away      float64
+}
+
+// csvFixture is one played fixture of a results file
+type csvFixture struct {
+	line      int
+	kickOff   time.Time
+	date      string
+	season    string
+	homeTeam  string
+	awayTeam  string
+	homeGoals int
+	awayGoals int
+	homeShots sql.NullFloat64
+	awayShots sql.NullFloat64
+	odds      []csvOdds
+}
+
+// readResultsFile parses a football-data.co.uk results file. Columns are found
+// by their header, as the set of columns differs between leagues and seasons.
+// Fixtures without a full-time score have not been played and are skipped.
+func readResultsFile(path string, season string) ([]csvFixture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	// older files pad some rows with empty trailing columns
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of %s: %v", path, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")] = i
+	}
+	for _, required := range []string{"Date", "HomeTeam", "AwayTeam", "FTHG", "FTAG"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%s has no %s column", path, required)
+		}
+	}
+
+	var fixtures []csvFixture
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		number := func(name string) (float64, bool) {
+			value, err := strconv.ParseFloat(field(name), 64)
+			return value, err == nil
+		}
+
+		homeGoals, ok := number("FTHG")
+		if !ok {
+			continue
+		}
+		awayGoals, ok := number("FTAG")
+		if !ok {
+			continue
+		}
+
+		date, err := parseResultDate(field("Date"), field("Time"))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+
+		f := csvFixture{
+			line:      line,
+			kickOff:   date,
+			date:      date.Format("2006-01-02T15:04:05-07:00"),
+			season:    season,
+			homeTeam:  field("HomeTeam"),
+			awayTeam:  field("AwayTeam"),
+			homeGoals: int(homeGoals),
+			awayGoals: int(awayGoals),
+		}
+		// seasons are named after the year they start in, like API-Football does
+		if f.season == "" {
+			year := date.Year()
+			if date.Month() < time.July {
+				year--
+			}
+			f.season = strconv.Itoa(year)
+		}
+		if shots, ok := number("HS"); ok {
+			f.homeShots = sql.NullFloat64{Float64: shots, Valid: true}
+		}
+		if shots, ok := number("AS"); ok {
+			f.awayShots = sql.NullFloat64{Float64: shots, Valid: true}
+		}
+
+		for _, b := range bookmakers {
+			// closing prices where the file has them, like the API's latest odds
+			for _, prefix := range []string{b.prefix + "C", b.prefix} {
+				home, okHome := number(prefix + "H")
+				draw, okDraw := number(prefix + "D")
+				away, okAway := number(prefix + "A")
+				if okHome && okDraw && okAway {
+					f.odds = append(f.odds, csvOdds{bookmaker: b.name, home: home, draw: draw, away: away})
+					break
+				}
+			}
+		}
+
+		fixtures = append(fixtures, f)
+	}
+
+	return fixtures, nil
+}
+
+// parseResultDate reads the dd/mm/yy or dd/mm/yyyy date and the optional
+// hh:mm kick-off time, which the files give in UK time. Without a kick-off
+// time the date is kept as midnight UTC, so it stays on the same day.
+func parseResultDate(date string, kickOff string) (time.Time, error) {
+	value := date
+	layouts := []string{"02/01/2006", "02/01/06"}
+	location := time.UTC
+	if kickOff != "" {
+		value = date + " " + kickOff
+		layouts = []string{"02/01/2006 15:04", "02/01/06 15:04"}
+
+		london, err := time.LoadLocation("Europe/London")
+		if err != nil {
+			return time.Time{}, err
+		}
+		location = london
+	}
+
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// sameFixtureWindow is how far apart two kick-offs of the same teams may be
+// and still be the same fixture. It is more than a day so a row without a
+// kick-off time, taken as midnight UTC, still matches a late kick-off or one
+// stored with another offset, and far less than the time between two
+// meetings of the same teams.
+const sameFixtureWindow = 36 * time.Hour
+
+// findFixture returns the stored fixture of the home and away team that
+// kicks off closest to kickOff within sameFixtureWindow, or sql.ErrNoRows.
+// Dates are compared as instants since the API stores them with the local
+// offset of the venue.
+func findFixture(tx *sql.Tx, homeTeam int, awayTeam int, kickOff time.Time) (int, error) {
+	rows, err := tx.Query("SELECT fixtureId, date FROM fixtures WHERE homeTeam = ? AND awayTeam = ?", homeTeam, awayTeam)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	found := 0
+	closest := sameFixtureWindow
+	for rows.Next() {
+		var fixtureID int
+		var date sql.NullString
+		if err := rows.Scan(&fixtureID, &date); err != nil {
+			return 0, err
+		}
+
+		stored, err := time.Parse(time.RFC3339, date.String)
+		if err != nil {
+			stored, err = time.Parse("2006-01-02", date.String)
+			if err != nil {
+				continue
+			}
+		}
+		difference := stored.Sub(kickOff).Abs()
+		if difference <= closest {
+			found = fixtureID
+			closest = difference
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if found == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+// importSummary counts what an import did
+type importSummary struct {
+	imported int
+	replaced int
+	skipped  int
+	odds     int
+}
+
+// importFixtures writes the fixtures of a file in one transaction. Imported
+// fixtures get negative ids so they never collide with API-Football's. A
+// fixture imported before is replaced, and one that getDataFromAPI already
+// stored is left alone.
+func importFixtures(fixtures []csvFixture, teams map[string]int, league int) (importSummary, error) {
+	var summary importSummary
+
+	tx, err := db.Begin()
+	if err != nil {
+		return summary, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var nextID int
+	if err := tx.QueryRow("SELECT COALESCE(MIN(fixtureId), 0) FROM fixtures").Scan(&nextID); err != nil {
+		return summary, fmt.Errorf("failed to find a free fixture id: %v", err)
+	}
+	nextID = min(nextID, 0) - 1
+
+	for _, f := range fixtures {
+		homeTeam := teams[normalizeTeamName(f.homeTeam)]
+		awayTeam := teams[normalizeTeamName(f.awayTeam)]
+
+		fixtureID, err := findFixture(tx, homeTeam, awayTeam, f.kickOff)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			fixtureID = nextID
+			nextID--
+			summary.imported++
+		case err != nil:
+			return summary, fmt.Errorf("failed to look up fixture: %v", err)
+		case fixtureID > 0:
+			summary.skipped++
+			continue
+		default:
+			summary.replaced++
+		}
+
+		for _, query := range []string{
+			"DELETE FROM fixtures WHERE fixtureId = ?",
+			"DELETE FROM score WHERE fixtureId = ?",
+			"DELETE FROM totalShots WHERE fixtureId = ?",
+			"DELETE FROM odds WHERE fixtureId = ?",
+		} {
+			if _, err := tx.Exec(query, fixtureID); err != nil {
+				return summary, fmt.Errorf("failed to clear fixture %d: %v", fixtureID, err)
+			}
+		}
+
+		// the files have shots but never possession
+		coverage := "none"
+		if f.homeShots.Valid && f.awayShots.Valid {
+			coverage = "partial"
+		}
+
+		_, err = tx.Exec("INSERT INTO fixtures (fixtureId, homeTeam, awayTeam, homeTeamScore, awayTeamScore, date, statsCoverage, league, season) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			fixtureID, homeTeam, awayTeam, f.homeGoals, f.awayGoals, f.date, coverage, league, f.season)
+		if err != nil {
+			return summary, fmt.Errorf("failed to insert fixture: %v", err)
+		}
+
+		for _, side := range []struct {
+			team  int
+			goals int
+			shots sql.NullFloat64
+		}{{homeTeam, f.homeGoals, f.homeShots}, {awayTeam, f.awayGoals, f.awayShots}} {
+			if _, err := tx.Exec("INSERT INTO score (fixtureId, team, score) VALUES (?, ?, ?)", fixtureID, side.team, side.goals); err != nil {
+				return summary, fmt.Errorf("failed to insert score: %v", err)
+			}
+			if _, err := tx.Exec("INSERT INTO totalShots (fixtureId, team, totalShots) VALUES (?, ?, ?)", fixtureID, side.team, side.shots); err != nil {
+				return summary, fmt.Errorf("failed to insert shots: %v", err)
+			}
+		}
+
+		for _, o := range f.odds {
+			_, err := tx.Exec("INSERT INTO odds (fixtureId, bookmaker, homeOdds, drawOdds, awayOdds, updated) VALUES (?, ?, ?, ?, ?, ?)",
+				fixtureID, o.bookmaker, o.home, o.draw, o.away, f.date)
+			if err != nil {
+				return summary, fmt.Errorf("failed to insert odds: %v", err)
+			}
+			summary.odds++
+		}
+	}
+
+	// the fixtures are older than what the rating engines have applied, so
+	// they have to replay everything on their next run
+	if summary.imported+summary.replaced > 0 {
+		if _, err := tx.Exec("DELETE FROM appliedFixtures"); err != nil {
+			return summary, fmt.Errorf("failed to reset applied fixtures: %v", err)
+		}
+	}
+
+	return summary, tx.Commit()
+}
+
+func main() {
+	league := flag.Int("league", 0, "API-Football league id the files belong to (required)")
+	season := flag.String("season", "", "season of the files, taken from each fixture's date if empty")
+	mapping := flag.String("mapping", "", "csv of name,teamId lines mapping the files' team names to API ids")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: importFootballData -league id [flags] results.csv...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *league == 0 || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := readConfig(&cfg, &cfg); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := initDB(cfg); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer closeDB()
+
+	if err := createTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+
+	if *mapping != "" {
+		if err := storeTeamMapping(*mapping); err != nil {
+			log.Fatalf("Failed to store team mapping: %v", err)
+		}
+	}
+
+	teams, err := loadTeamNames()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// read every file first, so an unknown team aborts before anything is written
+	files := make(map[string][]csvFixture)
+	var unmapped []string
+	for _, path := range flag.Args() {
+		fixtures, err := readResultsFile(path, *season)
+		if err != nil {
+			log.Fatal(err)
+		}
+		files[path] = fixtures
+
+		for _, f := range fixtures {
+			for _, name := range []string{f.homeTeam, f.awayTeam} {
+				if _, ok := teams[normalizeTeamName(name)]; !ok && !slices.Contains(unmapped, name) {
+					unmapped = append(unmapped, name)
+				}
+			}
+		}
+	}
+	if len(unmapped) > 0 {
+		slices.Sort(unmapped)
+		log.Fatalf("No team id for %s. Add them to a -mapping file as name,teamId lines.", strings.Join(unmapped, ", "))
+	}
+
+	changed := 0
+	for _, path := range flag.Args() {
+		summary, err := importFixtures(files[path], teams, *league)
+		if err != nil {
+			log.Fatalf("Failed to import %s: %v", path, err)
+		}
+		fmt.Printf("%s: %d fixtures imported, %d replaced, %d already stored by the API, %d odds\n",
+			path, summary.imported, summary.replaced, summary.skipped, summary.odds)
+		changed += summary.imported + summary.replaced
+	}
+	if changed > 0 {
+		fmt.Println("Run createEloRanking to replay the ratings with the imported fixtures")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "strings"
+
+var diacriticReplacer = strings.NewReplacer(
+	"ä", "a", "à", "a", "á", "a", "â", "a",
+	"ö", "o", "ò", "o", "ó", "o", "ô", "o",
+	"ü", "u", "ù", "u", "ú", "u", "û", "u",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ç", "c", "ñ", "n", "ß", "ss",
+	".", " ", "-", " ", "'", " ",
+)
+
+// clubAffixes carry no information about which team is meant
+var clubAffixes = map[string]bool{
+	"fc": true, "bsc": true, "sc": true, "ac": true, "afc": true, "cf": true, "club": true, "sport": true,
+}
+
+// normalizeTeamName reduces "FC Zürich" and "zurich" to the same key. The
+// keys of the teamAliases table are normalized with it, so generateChances
+// and importFootballData find teams by the same names.
+func normalizeTeamName(name string) string {
+	name = diacriticReplacer.Replace(strings.ToLower(name))
+
+	var words []string
+	for _, word := range strings.Fields(name) {
+		if !clubAffixes[word] {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}